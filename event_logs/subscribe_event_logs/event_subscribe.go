@@ -2,9 +2,12 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"log"
 
+	"ethereum-go-book/transactions/sinks"
+
 	"github.com/ethereum/go-ethereum"
 
 	"github.com/ethereum/go-ethereum/common"
@@ -13,6 +16,19 @@ import (
 )
 
 func main() {
+	sinkSpecs := flag.String("sink", "", "comma separated sinks: stdout, file:PATH or a webhook URL")
+	secret := flag.String("webhook-secret", "", "key to sign webhook requests with (HMAC-SHA256)")
+	flag.Parse()
+
+	// Besides printing them, every event log can be handed to one or more
+	// sinks: JSON lines on stdout, a rotating file or a webhook, e.g.
+	// -sink stdout,file:events.jsonl
+
+	sink, err := sinks.Open(*sinkSpecs, *secret)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer sink.Close()
 
 	// First thing we need to do in order to subscribe to event logs
 	// is dial to a websocket enabled Ethereum client. Fortunately
@@ -55,6 +71,10 @@ func main() {
 			log.Fatal(err)
 		case vLog := <-logs:
 			fmt.Printf("\tLog: %v\n", vLog) // pointer to event log
+
+			if err := sink.Send(context.Background(), sinks.NewRecord("log", vLog)); err != nil {
+				log.Printf("sink: %v", err)
+			}
 		}
 	}
 }
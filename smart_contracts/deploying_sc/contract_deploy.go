@@ -34,6 +34,7 @@ import (
 	"github.com/ethereum/go-ethereum/ethclient"
 
 	store "ethereum-go-book/smart_contracts/deploying_sc/contracts" // for demo
+	"ethereum-go-book/transactions/nonces"
 )
 
 func main() {
@@ -54,14 +55,20 @@ func main() {
 	}
 
 	fromAddress := crypto.PubkeyToAddress(*publicKeyECDSA)
-	nonce, err := client.PendingNonceAt(context.Background(), fromAddress)
+
+	// Nonces are handed out by the nonce manager in transactions/nonces,
+	// which the transfer tools use too.
+	nonceManager := nonces.New(nonces.DefaultPath(), client)
+	nonce, err := nonceManager.Next(context.Background(), fromAddress)
 	if err != nil {
 		log.Fatal(err)
 	}
 
+	abort := nonceManager.Abort(fromAddress, nonce)
+
 	gasPrice, err := client.SuggestGasPrice(context.Background())
 	if err != nil {
-		log.Fatal(err)
+		abort(err)
 	}
 
 	// Assuming you've imported the newly created Go package file generated
@@ -88,7 +95,7 @@ func main() {
 	input := "1.0"
 	address, tx, instance, err := store.DeployStore(auth, client, input)
 	if err != nil {
-		log.Fatal(err)
+		abort(err)
 	}
 
 	fmt.Printf("\tAddress: %v\n", address.Hex())
@@ -20,23 +20,34 @@ package main
 
   $ abigen --bin=contracts/Store.bin --abi=contracts/Store.abi --pkg=store --out=contracts/Store.go
 
+  Pass -access-list to send SetItem with an EIP-2930 access list when that
+  saves gas.
+
+  $ go run contract_write.go -access-list
+
 */
 import (
 	"context"
 	"crypto/ecdsa"
+	"flag"
 	"fmt"
 	"log"
 	"math/big"
 
 	store "ethereum-go-book/smart_contracts/writing_sc/contracts"
+	"ethereum-go-book/transactions/accesslist"
+	"ethereum-go-book/transactions/nonces"
 
 	"github.com/ethereum/go-ethereum/accounts/abi/bind"
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/ethclient"
 )
 
 func main() {
+	useAccessList := flag.Bool("access-list", false, "send with an EIP-2930 access list when it saves gas")
+	flag.Parse()
 
 	client, err := ethclient.Dial("https://rinkeby.infura.io")
 	if err != nil {
@@ -58,16 +69,21 @@ func main() {
 
 	fromAddress := crypto.PubkeyToAddress(*publicKeyECDSA)
 
-	// We'll also need to figure the nonce and gas price.
+	// We'll also need to figure the nonce and gas price. The nonce is taken
+	// from the shared nonce manager (see transactions/nonces) so this can run
+	// alongside the other tools sending from the same key.
 
-	nonce, err := client.PendingNonceAt(context.Background(), fromAddress)
+	nonceManager := nonces.New(nonces.DefaultPath(), client)
+	nonce, err := nonceManager.Next(context.Background(), fromAddress)
 	if err != nil {
 		log.Fatal(err)
 	}
 
+	abort := nonceManager.Abort(fromAddress, nonce)
+
 	gasPrice, err := client.SuggestGasPrice(context.Background())
 	if err != nil {
-		log.Fatal(err)
+		abort(err)
 	}
 
 	// Next we create a new keyed transactor which takes in the private key.
@@ -89,7 +105,7 @@ func main() {
 	address := common.HexToAddress("0x35386c483387b87d87eafc0f35504e9539a0b8f2")
 	instance, err := store.NewStore(address, client)
 	if err != nil {
-		log.Fatal(err)
+		abort(err)
 	}
 
 	// The smart contract that we created has an external method called SetItem which
@@ -106,9 +122,19 @@ func main() {
 	copy(key[:], []byte("foo"))
 	copy(value[:], []byte("bar"))
 
+	// With -access-list the transaction is only built and signed here, not sent,
+	// so that we can first ask the node for an EIP-2930 access list of what SetItem
+	// touches: the contract and the storage slot of the key. If declaring them up front
+	// makes the call cheaper, the transaction is rebuilt as a type 1 transaction carrying
+	// the list and signed again.
+
+	auth.NoSend = *useAccessList
 	tx, err := instance.SetItem(auth, key, value)
+	if err == nil && *useAccessList {
+		tx, err = sendWithAccessList(client, privateKey, fromAddress, tx)
+	}
 	if err != nil {
-		log.Fatal(err)
+		abort(err)
 	}
 
 	fmt.Printf("\ttx sent: %s\n", tx.Hash().Hex())
@@ -129,3 +155,31 @@ func main() {
 		      run the app commenting out the instance.SetItem() part
 	*/
 }
+
+// sendWithAccessList sends tx, which was signed but not sent, with an access
+// list if that lowers its gas estimate. It is sent as it is otherwise,
+// including when the node can't create an access list.
+func sendWithAccessList(client *ethclient.Client, key *ecdsa.PrivateKey, from common.Address, tx *types.Transaction) (*types.Transaction, error) {
+	ctx := context.Background()
+	cmp, err := accesslist.Compare(ctx, client.Client(), accesslist.Msg(tx, from))
+	if err != nil {
+		log.Printf("no access list: %v", err)
+		return tx, client.SendTransaction(ctx, tx)
+	}
+	fmt.Printf("\t%v\n", cmp)
+
+	if cmp.Saves() {
+		chainID, err := client.ChainID(ctx)
+		if err != nil {
+			return nil, err
+		}
+		unsigned, err := accesslist.WithList(tx, chainID, cmp.AccessList, cmp.With)
+		if err != nil {
+			return nil, err
+		}
+		if tx, err = types.SignTx(unsigned, types.LatestSignerForChainID(chainID), key); err != nil {
+			return nil, err
+		}
+	}
+	return tx, client.SendTransaction(ctx, tx)
+}
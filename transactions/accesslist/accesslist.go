@@ -0,0 +1,163 @@
+// Package accesslist generates EIP-2930 access lists for contract calls.
+//
+// A transaction can declare up front the accounts and storage slots it will
+// touch. Declaring them costs 2400 gas per address and 1900 per slot, and
+// makes the first access to each of them 100 gas instead of 2600 for an
+// account or 2100 for a slot. Whether that adds up to a saving depends on
+// the call, so the list is built with eth_createAccessList, which runs the
+// call on the node and records what it touched, and only used when gas
+// estimates with and without it show it is cheaper.
+package accesslist
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+
+	ethereum "github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// Comparison holds a generated access list and the gas estimates of the
+// call without and with it.
+type Comparison struct {
+	AccessList types.AccessList
+	Without    uint64
+	With       uint64
+}
+
+// Saves reports whether the list lowers the estimated gas.
+func (c *Comparison) Saves() bool {
+	return len(c.AccessList) > 0 && c.With < c.Without
+}
+
+func (c *Comparison) String() string {
+	slots := 0
+	for _, tuple := range c.AccessList {
+		slots += len(tuple.StorageKeys)
+	}
+	return fmt.Sprintf("access list of %d addresses and %d slots: %d gas without, %d gas with",
+		len(c.AccessList), slots, c.Without, c.With)
+}
+
+// Create runs eth_createAccessList for the call against the pending state.
+// It returns the list and the gas the call used with it.
+func Create(ctx context.Context, c *rpc.Client, msg ethereum.CallMsg) (types.AccessList, uint64, error) {
+	var result struct {
+		AccessList types.AccessList `json:"accessList"`
+		GasUsed    hexutil.Uint64   `json:"gasUsed"`
+		Error      string           `json:"error,omitempty"`
+	}
+	if err := c.CallContext(ctx, &result, "eth_createAccessList", toCallArg(msg), "pending"); err != nil {
+		return nil, 0, err
+	}
+	if result.Error != "" {
+		return nil, 0, fmt.Errorf("accesslist: call failed: %s", result.Error)
+	}
+	return result.AccessList, uint64(result.GasUsed), nil
+}
+
+// Compare generates the access list of the call and estimates its gas
+// without and with the list.
+func Compare(ctx context.Context, c *rpc.Client, msg ethereum.CallMsg) (*Comparison, error) {
+	list, _, err := Create(ctx, c, msg)
+	if err != nil {
+		return nil, err
+	}
+	msg.AccessList = nil
+	without, err := estimateGas(ctx, c, msg)
+	if err != nil {
+		return nil, err
+	}
+	cmp := &Comparison{AccessList: list, Without: without, With: without}
+	if len(list) == 0 {
+		return cmp, nil
+	}
+	msg.AccessList = list
+	if cmp.With, err = estimateGas(ctx, c, msg); err != nil {
+		return nil, err
+	}
+	return cmp, nil
+}
+
+// Msg returns the call a transaction from the given sender makes, leaving
+// the gas and fees for the node to pick.
+func Msg(tx *types.Transaction, from common.Address) ethereum.CallMsg {
+	return ethereum.CallMsg{
+		From:  from,
+		To:    tx.To(),
+		Value: tx.Value(),
+		Data:  tx.Data(),
+	}
+}
+
+// WithList returns an unsigned copy of tx carrying the access list, with the
+// same nonce and fees and the given gas limit, which should be the estimate
+// made with the list. A legacy transaction becomes an access list (type 1)
+// transaction at the same gas price; dynamic fee (type 2) transactions keep
+// their type.
+func WithList(tx *types.Transaction, chainID *big.Int, list types.AccessList, gas uint64) (*types.Transaction, error) {
+	if tx.To() == nil {
+		return nil, errors.New("accesslist: contract creation")
+	}
+	switch tx.Type() {
+	case types.LegacyTxType, types.AccessListTxType:
+		return types.NewTx(&types.AccessListTx{
+			ChainID:    chainID,
+			Nonce:      tx.Nonce(),
+			GasPrice:   tx.GasPrice(),
+			Gas:        gas,
+			To:         tx.To(),
+			Value:      tx.Value(),
+			Data:       tx.Data(),
+			AccessList: list,
+		}), nil
+	case types.DynamicFeeTxType:
+		return types.NewTx(&types.DynamicFeeTx{
+			ChainID:    chainID,
+			Nonce:      tx.Nonce(),
+			GasTipCap:  tx.GasTipCap(),
+			GasFeeCap:  tx.GasFeeCap(),
+			Gas:        gas,
+			To:         tx.To(),
+			Value:      tx.Value(),
+			Data:       tx.Data(),
+			AccessList: list,
+		}), nil
+	default:
+		return nil, fmt.Errorf("accesslist: unsupported transaction type %d", tx.Type())
+	}
+}
+
+func estimateGas(ctx context.Context, c *rpc.Client, msg ethereum.CallMsg) (uint64, error) {
+	var gas hexutil.Uint64
+	if err := c.CallContext(ctx, &gas, "eth_estimateGas", toCallArg(msg)); err != nil {
+		return 0, err
+	}
+	return uint64(gas), nil
+}
+
+// toCallArg encodes a call the way the eth namespace expects its argument.
+func toCallArg(msg ethereum.CallMsg) interface{} {
+	arg := map[string]interface{}{
+		"from": msg.From,
+		"to":   msg.To,
+	}
+	if len(msg.Data) > 0 {
+		arg["data"] = hexutil.Bytes(msg.Data)
+	}
+	if msg.Value != nil {
+		arg["value"] = (*hexutil.Big)(msg.Value)
+	}
+	if msg.Gas != 0 {
+		arg["gas"] = hexutil.Uint64(msg.Gas)
+	}
+	if msg.AccessList != nil {
+		arg["accessList"] = msg.AccessList
+	}
+	return arg
+}
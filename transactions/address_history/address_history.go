@@ -0,0 +1,112 @@
+package main
+
+/*
+
+  Transaction History of an Address
+
+  There's no RPC method that returns all the transactions of an account. To
+  answer "what did this address send and receive?" we have to walk the blocks
+  ourselves and keep an index by address. The index remembers the blocks it
+  covers, so running the indexer again only looks at the new blocks. It has
+  to stay one unbroken range: a later run must start at or before the block
+  after the last one indexed, and can't go back before the first.
+
+  Index a range of blocks:
+
+  $ go run address_history.go -mode index -db ./txindex -from 5671700 -to 5671744
+
+  Query the index, one page at a time:
+
+  $ go run address_history.go -mode query -db ./txindex \
+      -address 0x71c7656ec7ab88b098defb751b7401b5f6d8976f -limit 20
+  $ go run address_history.go -mode query -db ./txindex \
+      -address 0x71c7656ec7ab88b098defb751b7401b5f6d8976f -limit 20 -cursor <next>
+
+*/
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+
+	"ethereum-go-book/transactions/txindex"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+func main() {
+	url := flag.String("rpc", "https://mainnet.infura.io", "JSON-RPC endpoint")
+	path := flag.String("db", "./txindex", "index directory")
+	mode := flag.String("mode", "query", "\"index\" a block range or \"query\" an address")
+	from := flag.Uint64("from", 0, "first block to index")
+	to := flag.Uint64("to", 0, "last block to index")
+	address := flag.String("address", "", "address to query")
+	cursor := flag.String("cursor", "", "cursor returned by the previous page")
+	limit := flag.Int("limit", txindex.DefaultPageSize, "entries per page")
+	flag.Parse()
+
+	db, err := txindex.Open(*path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	switch *mode {
+	case "index":
+		client, err := rpc.Dial(*url)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		indexer, err := txindex.NewIndexer(context.Background(), db, client)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		err = indexer.Index(context.Background(), *from, *to, func(block uint64, txs int) {
+			fmt.Printf("\tIndexed block %d: %d transactions\n", block, txs)
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+
+	case "query":
+		if !common.IsHexAddress(*address) {
+			log.Fatalf("invalid address %q", *address)
+		}
+
+		page, err := db.Query(common.HexToAddress(*address), *cursor, *limit)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		for _, entry := range page.Entries {
+			to := "none"
+			if entry.To != nil {
+				to = entry.To.Hex()
+			}
+			if entry.Creation {
+				to += " (contract creation)"
+			}
+			fmt.Printf("\n\tTx Hash: %v\n", entry.TxHash.Hex())
+			fmt.Printf("\tBlock: %d\n", entry.Block)
+			fmt.Printf("\tFrom: %v\n", entry.From.Hex())
+			fmt.Printf("\tTo: %v\n", to)
+			fmt.Printf("\tValue: %v\n", entry.Value)
+			fmt.Printf("\tStatus: %d\n", entry.Status)
+		}
+
+		if last, ok, err := db.LastBlock(); err == nil && ok {
+			if first, _, err := db.FirstBlock(); err == nil {
+				fmt.Printf("\n\tIndexed blocks %d to %d\n", first, last)
+			}
+		}
+		if page.Next != "" {
+			fmt.Printf("\tNext page: -cursor %s\n", page.Next)
+		}
+
+	default:
+		log.Fatalf("unknown mode %q", *mode)
+	}
+}
@@ -0,0 +1,122 @@
+// Package addresses parses hex addresses strictly.
+//
+// common.HexToAddress never fails: it drops invalid characters, and pads or
+// cuts the input to 20 bytes, so a mistyped address silently becomes some
+// other address. Parse only accepts 0x followed by exactly 40 hex digits, and
+// when the digits are mixed case it checks them against the EIP-55 checksum,
+// which catches most typos. Addresses in all lower or all upper case carry
+// no checksum and are accepted as they are.
+//
+// EIP-1191 is a variant of the checksum that mixes in the chain ID, used by
+// chains such as RSK so that an address copied from one chain fails the
+// checksum on another. ParseForChain checks that one instead.
+package addresses
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+var (
+	// ErrPrefix is returned when an address doesn't start with 0x.
+	ErrPrefix = errors.New("missing 0x prefix")
+	// ErrLength is returned when an address doesn't have 40 hex digits.
+	ErrLength = errors.New("wrong length")
+	// ErrHex is returned when an address has characters other than hex digits.
+	ErrHex = errors.New("not hexadecimal")
+	// ErrChecksum is returned when a mixed case address fails its checksum.
+	ErrChecksum = errors.New("bad checksum")
+)
+
+// Error describes why an input isn't a valid address.
+type Error struct {
+	Input  string
+	Err    error  // one of ErrPrefix, ErrLength, ErrHex or ErrChecksum
+	Detail string // what was expected, if known
+}
+
+func (e *Error) Error() string {
+	msg := fmt.Sprintf("invalid address %q: %v", e.Input, e.Err)
+	if e.Detail != "" {
+		msg += " (" + e.Detail + ")"
+	}
+	return msg
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Parse parses an address, checking the EIP-55 checksum of mixed case input.
+func Parse(s string) (common.Address, error) {
+	return ParseForChain(s, nil)
+}
+
+// ParseForChain parses an address, checking the EIP-1191 checksum for the
+// chain of mixed case input. A nil chain ID checks the EIP-55 checksum.
+func ParseForChain(s string, chainID *big.Int) (common.Address, error) {
+	if !strings.HasPrefix(s, "0x") && !strings.HasPrefix(s, "0X") {
+		return common.Address{}, &Error{Input: s, Err: ErrPrefix}
+	}
+	digits := s[2:]
+	if len(digits) != 2*common.AddressLength {
+		return common.Address{}, &Error{
+			Input:  s,
+			Err:    ErrLength,
+			Detail: fmt.Sprintf("have %d hex digits, want %d", len(digits), 2*common.AddressLength),
+		}
+	}
+	for i, c := range digits {
+		if !isHex(c) {
+			return common.Address{}, &Error{Input: s, Err: ErrHex, Detail: fmt.Sprintf("%q at position %d", c, i+2)}
+		}
+	}
+
+	addr := common.HexToAddress(digits)
+	if digits == strings.ToLower(digits) || digits == strings.ToUpper(digits) {
+		return addr, nil
+	}
+	if want := Checksum(addr, chainID); digits != want[2:] {
+		return common.Address{}, &Error{Input: s, Err: ErrChecksum, Detail: "did you mean " + want + "?"}
+	}
+	return addr, nil
+}
+
+// Checksum returns the address in mixed case, following EIP-1191 for the
+// chain, or EIP-55 when the chain ID is nil.
+//
+// The hex digits a-f are upper cased where the matching nibble of the
+// keccak-256 hash of the lower case address is 8 or more. EIP-1191 hashes
+// the decimal chain ID and the 0x prefixed address instead.
+func Checksum(addr common.Address, chainID *big.Int) string {
+	lower := strings.ToLower(addr.Hex()[2:])
+	input := lower
+	if chainID != nil {
+		input = chainID.String() + "0x" + lower
+	}
+	hash := crypto.Keccak256([]byte(input))
+
+	result := []byte(lower)
+	for i, c := range result {
+		if c < 'a' {
+			continue
+		}
+		nibble := hash[i/2]
+		if i%2 == 0 {
+			nibble >>= 4
+		}
+		if nibble&0xf >= 8 {
+			result[i] = c - 'a' + 'A'
+		}
+	}
+	return "0x" + string(result)
+}
+
+func isHex(c rune) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
@@ -0,0 +1,102 @@
+package addresses
+
+import (
+	"errors"
+	"math/big"
+	"strings"
+	"testing"
+)
+
+// Test vectors from EIP-55 and EIP-1191.
+var checksumTests = []struct {
+	chainID *big.Int
+	address string
+}{
+	{nil, "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed"},
+	{nil, "0xfB6916095ca1df60bB79Ce92cE3Ea74c37c5d359"},
+	{nil, "0xdbF03B407c01E7cD3CBea99509d93f8DDDC8C6FB"},
+	{nil, "0xD1220A0cf47c7B9Be7A2E6BA89F429762e7b9aDb"},
+
+	{big.NewInt(30), "0x5aaEB6053f3e94c9b9a09f33669435E7ef1bEAeD"},
+	{big.NewInt(30), "0xFb6916095cA1Df60bb79ce92cE3EA74c37c5d359"},
+	{big.NewInt(30), "0xDBF03B407c01E7CD3cBea99509D93F8Dddc8C6FB"},
+	{big.NewInt(30), "0xD1220A0Cf47c7B9BE7a2e6ba89F429762E7B9adB"},
+	{big.NewInt(30), "0x3599689E6292B81B2D85451025146515070129Bb"},
+	{big.NewInt(30), "0x52908400098527886E0F7030069857D2E4169ee7"},
+	{big.NewInt(30), "0x8617E340b3D01Fa5f11f306f4090fd50E238070D"},
+	{big.NewInt(30), "0x27b1FdB04752BBc536007A920D24ACB045561c26"},
+	{big.NewInt(30), "0xDe709F2102306220921060314715629080e2FB77"},
+
+	{big.NewInt(31), "0x5aAeb6053F3e94c9b9A09F33669435E7EF1BEaEd"},
+	{big.NewInt(31), "0xFb6916095CA1dF60bb79CE92ce3Ea74C37c5D359"},
+	{big.NewInt(31), "0xdbF03B407C01E7cd3cbEa99509D93f8dDDc8C6fB"},
+	{big.NewInt(31), "0xd1220a0CF47c7B9Be7A2E6Ba89f429762E7b9adB"},
+	{big.NewInt(31), "0x3599689e6292b81b2D85451025146515070129Bb"},
+	{big.NewInt(31), "0x52908400098527886E0F7030069857D2e4169EE7"},
+}
+
+func TestChecksum(t *testing.T) {
+	for _, test := range checksumTests {
+		addr, err := ParseForChain(test.address, test.chainID)
+		if err != nil {
+			t.Errorf("ParseForChain(%s, %v): %v", test.address, test.chainID, err)
+			continue
+		}
+		if got := Checksum(addr, test.chainID); got != test.address {
+			t.Errorf("Checksum(%s, %v) = %s", test.address, test.chainID, got)
+		}
+	}
+}
+
+func TestParseForChain(t *testing.T) {
+	const valid = "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed"
+	tests := []struct {
+		input   string
+		chainID *big.Int
+		err     error // nil if the input is valid
+	}{
+		{valid, nil, nil},
+		{strings.ToLower(valid), nil, nil},
+		{"0x" + strings.ToUpper(valid[2:]), nil, nil},
+		{"0X" + valid[2:], nil, nil},
+		{strings.ToLower(valid), big.NewInt(30), nil},
+
+		{valid[2:], nil, ErrPrefix},
+		{"", nil, ErrPrefix},
+		{valid[:len(valid)-1], nil, ErrLength},
+		{valid + "0", nil, ErrLength},
+		{"0x", nil, ErrLength},
+		{valid[:len(valid)-1] + "g", nil, ErrHex},
+		{"0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeA d", nil, ErrHex},
+		{"0x5AAeb6053F3E94C9b9A09f33669435E7Ef1BeAed", nil, ErrChecksum},
+		// An EIP-55 address fails the checksum of an EIP-1191 chain, and
+		// an address of chain 30 fails it on chain 31.
+		{valid, big.NewInt(30), ErrChecksum},
+		{"0x5aaEB6053f3e94c9b9a09f33669435E7ef1bEAeD", big.NewInt(31), ErrChecksum},
+	}
+	for _, test := range tests {
+		addr, err := ParseForChain(test.input, test.chainID)
+		if test.err == nil {
+			if err != nil {
+				t.Errorf("ParseForChain(%q, %v): %v", test.input, test.chainID, err)
+			} else if Checksum(addr, nil) != valid {
+				t.Errorf("ParseForChain(%q, %v) = %s, want %s", test.input, test.chainID, addr.Hex(), valid)
+			}
+			continue
+		}
+		if !errors.Is(err, test.err) {
+			t.Errorf("ParseForChain(%q, %v) error = %v, want %v", test.input, test.chainID, err, test.err)
+		}
+		var addrErr *Error
+		if !errors.As(err, &addrErr) || addrErr.Input != test.input {
+			t.Errorf("ParseForChain(%q, %v) error %v doesn't describe the input", test.input, test.chainID, err)
+		}
+	}
+}
+
+func TestChecksumSuggestion(t *testing.T) {
+	_, err := Parse("0x5AAeb6053F3E94C9b9A09f33669435E7Ef1BeAed")
+	if err == nil || !strings.Contains(err.Error(), "did you mean 0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed?") {
+		t.Errorf("error %v doesn't suggest the checksummed address", err)
+	}
+}
@@ -0,0 +1,234 @@
+package main
+
+/*
+
+  Batch Payouts
+
+  Paying a few hundred people one transfer_eth at a time is slow and easy to
+  get wrong. This tool reads the payments from a CSV file of recipient and
+  amount rows:
+
+    recipient,amount
+    0x4592d8f8d7b001e72cb26a73e4fa1806a51ac79d,0.25
+    0x96216849c49358B10257cb55b28eA603c874b05E,1.5
+
+  Every row is checked first, and the whole run is refused if any of them is
+  wrong or the balance can't cover all payments and their fees. Payments are
+  then sent one after the other with consecutive nonces.
+
+  Each signed transaction is written to a journal before it is broadcast.
+  If the run is interrupted, start it again with the same file and journal:
+  payments already made are skipped, and those signed but maybe not sent are
+  rebroadcast as the very same transaction, so nobody is paid twice.
+  The journal remembers the hash of the file it was started with and
+  refuses any other, so leave the file untouched until the run is over and
+  start a new journal for the next one.
+
+  A payment whose nonce was taken by another transaction is reported as
+  replaced and makes the tool exit with an error: check by hand whether it
+  was paid, it is never retried automatically.
+
+  $ go run batch_payout.go -csv payouts.csv -journal payouts.journal -dry-run
+  $ go run batch_payout.go -csv payouts.csv -journal payouts.journal
+
+*/
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"time"
+
+	"ethereum-go-book/transactions/fees"
+	"ethereum-go-book/transactions/nonces"
+	"ethereum-go-book/transactions/payouts"
+	"ethereum-go-book/transactions/preflight"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+func main() {
+	url := flag.String("rpc", "https://rinkeby.infura.io", "JSON-RPC endpoint")
+	csvPath := flag.String("csv", "", "file of recipient,amount rows")
+	unit := flag.String("unit", "ether", "unit of the amounts: ether, gwei or wei")
+	journalPath := flag.String("journal", "", "journal of the run, reuse it to resume (required)")
+	keyHex := flag.String("key", "fad9c8855b740a0b7ed4c221dbad0f33a83a49cad6b3fe8d5817ac83d38b6a19", "private key of the paying account")
+	chainIDFlag := flag.Int64("chain-id", 4, "EIP-155 chain ID of the network to send on")
+	strategyName := flag.String("strategy", "normal", "fee strategy: slow, normal or fast")
+	wait := flag.Duration("wait", 5*time.Minute, "how long to wait for each payment to be mined at the end")
+	dryRun := flag.Bool("dry-run", false, "validate the file and show what would be paid, without sending")
+	yes := flag.Bool("yes", false, "pay without asking for confirmation")
+	flag.Parse()
+
+	if *journalPath == "" {
+		log.Fatal("-journal is required")
+	}
+	ctx := context.Background()
+
+	data, err := os.ReadFile(*csvPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	payments, err := payouts.ReadCSV(bytes.NewReader(data), payouts.Unit(*unit))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	client, err := ethclient.Dial(*url)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	chainID := big.NewInt(*chainIDFlag)
+	nodeChainID, err := client.ChainID(ctx)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if nodeChainID.Cmp(chainID) != 0 {
+		log.Fatalf("node is on chain %v, expected %v", nodeChainID, chainID)
+	}
+
+	if err := payouts.CheckRecipients(ctx, client, payments); err != nil {
+		log.Fatal(err)
+	}
+
+	key, err := crypto.HexToECDSA(*keyHex)
+	if err != nil {
+		log.Fatal(err)
+	}
+	from := crypto.PubkeyToAddress(key.PublicKey)
+
+	// All payments offer the same fees, estimated once up front.
+
+	fee, err := estimateFees(ctx, client, *strategyName)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	journal, err := payouts.OpenJournal(*journalPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer journal.Close()
+	if err := journal.Bind(crypto.Keccak256Hash(data)); err != nil {
+		log.Fatalf("%s: %v", *journalPath, err)
+	}
+
+	// Only what hasn't been signed in an earlier run still needs funds.
+
+	needed := new(big.Int)
+	remaining := 0
+	for _, p := range payments {
+		entry, err := journal.Get(p)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if entry == nil {
+			needed.Add(needed, fee.MaxCost(p.Amount))
+			remaining++
+		}
+	}
+	balance, err := client.PendingBalanceAt(ctx, from)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Printf("From:      %s\n", from.Hex())
+	fmt.Printf("Payments:  %d, %d not yet signed\n", len(payments), remaining)
+	fmt.Printf("Total:     %s ETH\n", formatEther(payouts.Total(payments)))
+	fmt.Printf("Max cost:  %s ETH of the remaining payments, fees included\n", formatEther(needed))
+	fmt.Printf("Balance:   %s ETH\n", formatEther(balance))
+	if balance.Cmp(needed) < 0 {
+		log.Fatal("balance doesn't cover the remaining payments")
+	}
+	if *dryRun {
+		for _, p := range payments {
+			fmt.Printf("  row %d: %s ETH to %s\n", p.Row, formatEther(p.Amount), p.To.Hex())
+		}
+		return
+	}
+	if !*yes && !preflight.Confirm(os.Stdin, os.Stdout, "Send payments?") {
+		log.Fatal("aborted")
+	}
+
+	payer := payouts.NewPayer(client, journal, nonces.New(nonces.DefaultPath(), client), key, chainID, fee)
+
+	// Send everything first, then wait for the receipts: waiting after each
+	// payment would take a block per row.
+
+	var sent []*payouts.Payment
+	entries := make(map[*payouts.Payment]*payouts.Entry)
+	replaced := 0
+	for _, p := range payments {
+		entry, err := payer.Send(ctx, p)
+		if err != nil {
+			log.Fatalf("row %d: %v", p.Row, err)
+		}
+		entries[p] = entry
+		switch {
+		case entry.Status == payouts.Replaced:
+			fmt.Printf("row %d: nonce %d was used by another transaction, check whether %s was paid\n", p.Row, entry.Nonce, p.To.Hex())
+			replaced++
+		case entry.Status.Done():
+			fmt.Printf("row %d: already %s (%s)\n", p.Row, entry.Status, entry.Hash.Hex())
+		case entry.Error != "":
+			fmt.Printf("row %d: %s, %s: %s\n", p.Row, entry.Status, entry.Hash.Hex(), entry.Error)
+			sent = append(sent, p)
+		default:
+			fmt.Printf("row %d: %s %s\n", p.Row, entry.Status, entry.Hash.Hex())
+			sent = append(sent, p)
+		}
+	}
+
+	unfinished := 0
+	for _, p := range sent {
+		entry := entries[p]
+		if err := payer.Wait(ctx, p, entry, *wait); err != nil {
+			log.Fatalf("row %d: %v", p.Row, err)
+		}
+		if entry.Status == payouts.Replaced {
+			fmt.Printf("row %d: nonce %d was used by another transaction, check whether %s was paid\n", p.Row, entry.Nonce, p.To.Hex())
+			replaced++
+			continue
+		}
+		fmt.Printf("row %d: %s %s\n", p.Row, entry.Status, entry.Hash.Hex())
+		if !entry.Status.Done() {
+			unfinished++
+		}
+	}
+	if unfinished > 0 {
+		fmt.Printf("%d payment(s) not mined yet, run again with the same journal to resume\n", unfinished)
+	}
+	if replaced > 0 {
+		log.Fatalf("%d payment(s) replaced by other transactions, check them before paying again", replaced)
+	}
+}
+
+func estimateFees(ctx context.Context, client *ethclient.Client, strategyName string) (*payouts.Fees, error) {
+	strategy, err := fees.ParseStrategy(strategyName)
+	if err != nil {
+		return nil, err
+	}
+	estimate, err := fees.NewEstimator(client, 0).Estimate(ctx, strategy)
+	if errors.Is(err, fees.ErrNoBaseFee) {
+		gasPrice, err := client.SuggestGasPrice(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return &payouts.Fees{GasPrice: gasPrice}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &payouts.Fees{GasTipCap: estimate.GasTipCap, GasFeeCap: estimate.GasFeeCap}, nil
+}
+
+func formatEther(wei *big.Int) string {
+	return new(big.Rat).SetFrac(wei, big.NewInt(params.Ether)).FloatString(18)
+}
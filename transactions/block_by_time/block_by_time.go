@@ -0,0 +1,83 @@
+package main
+
+/*
+
+  Finding a Block by Timestamp
+
+  In the blocks section we went from a block to its timestamp. Reports often
+  need the opposite: "what was the balance at the end of the month?" means
+  "what was the balance at the last block mined before midnight UTC?".
+
+  There is no RPC method for this, but block timestamps only ever increase,
+  so we can search for the block using HeaderByNumber.
+
+  $ go run block_by_time.go -time 2018-08-31T23:59:59Z -mode last \
+      -account 0x71c7656ec7ab88b098defb751b7401b5f6d8976f
+
+*/
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"time"
+
+	"ethereum-go-book/transactions/blocktime"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+)
+
+func main() {
+	url := flag.String("rpc", "https://mainnet.infura.io", "JSON-RPC endpoint")
+	at := flag.String("time", "2018-08-31T23:59:59Z", "UTC timestamp in RFC 3339 format")
+	mode := flag.String("mode", "last", "\"first\" block at or after the time, or \"last\" block at or before it")
+	account := flag.String("account", "", "optional account to read the balance of at the block found")
+	flag.Parse()
+
+	t, err := time.Parse(time.RFC3339, *at)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	client, err := ethclient.Dial(*url)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// The finder keeps the headers it fetched during the search, so looking
+	// up several timestamps close to each other only costs a few extra calls.
+
+	finder := blocktime.NewFinder(client, blocktime.DefaultCacheSize)
+
+	var header *types.Header
+	switch *mode {
+	case "first":
+		header, err = finder.FirstAtOrAfter(context.Background(), t)
+	case "last":
+		header, err = finder.LastAtOrBefore(context.Background(), t)
+	default:
+		log.Fatalf("unknown mode %q", *mode)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Printf("\tBlock number: %v\n", header.Number)
+	fmt.Printf("\tBlock hash: %v\n", header.Hash().Hex())
+	fmt.Printf("\tBlock time: %v\n", time.Unix(int64(header.Time), 0).UTC().Format(time.RFC3339))
+
+	if *account == "" {
+		return
+	}
+
+	// With the block number in hand, any state query can be pinned to it.
+
+	balance, err := client.BalanceAt(context.Background(), common.HexToAddress(*account), header.Number)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Printf("\tBalance of %v: %v\n", *account, balance)
+}
@@ -0,0 +1,195 @@
+// Package blocktime maps wall-clock timestamps to block numbers.
+//
+// Block timestamps only ever increase, so the block closest to a point in
+// time can be found by searching over HeaderByNumber. Block times are roughly
+// regular, which makes interpolation a much better first guess than plain
+// bisection; the search alternates between the two so that a chain with
+// irregular block times still converges in a logarithmic number of calls.
+package blocktime
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+var (
+	// ErrFuture is returned when no block has been mined at or after the
+	// requested time yet.
+	ErrFuture = errors.New("timestamp is after the latest block")
+
+	// ErrBeforeGenesis is returned when the requested time is before the
+	// genesis block, so there is no block at or before it.
+	ErrBeforeGenesis = errors.New("timestamp is before the genesis block")
+)
+
+// DefaultCacheSize is the number of headers kept by a Finder created with a
+// non-positive cache size.
+const DefaultCacheSize = 128
+
+// HeaderReader is the subset of ethclient.Client used by the Finder.
+type HeaderReader interface {
+	HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error)
+}
+
+// Finder looks up blocks by timestamp. It keeps a small cache of the headers
+// it has fetched, so that repeated lookups around the same time (every day of
+// a month, for example) share most of their RPC calls. A Finder is safe for
+// concurrent use.
+type Finder struct {
+	reader HeaderReader
+
+	mu    sync.Mutex
+	cache map[uint64]*types.Header
+	order []uint64 // insertion order, oldest first
+	size  int
+}
+
+// NewFinder returns a Finder reading headers from r and caching at most
+// cacheSize of them.
+func NewFinder(r HeaderReader, cacheSize int) *Finder {
+	if cacheSize <= 0 {
+		cacheSize = DefaultCacheSize
+	}
+	return &Finder{
+		reader: r,
+		cache:  make(map[uint64]*types.Header, cacheSize),
+		size:   cacheSize,
+	}
+}
+
+// FirstAtOrAfter returns the header of the first block whose timestamp is
+// at or after t.
+func (f *Finder) FirstAtOrAfter(ctx context.Context, t time.Time) (*types.Header, error) {
+	head, err := f.reader.HeaderByNumber(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	ts := unix(t)
+	if head.Time < ts {
+		return nil, ErrFuture
+	}
+	return f.search(ctx, ts, head)
+}
+
+// LastAtOrBefore returns the header of the last block whose timestamp is at
+// or before t. This is the block to query for a "state at time t" question
+// such as the balance of an account at the end of a month.
+func (f *Finder) LastAtOrBefore(ctx context.Context, t time.Time) (*types.Header, error) {
+	head, err := f.reader.HeaderByNumber(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	ts := unix(t)
+	if head.Time <= ts {
+		return head, nil
+	}
+	// The last block at or before ts is the one right before the first
+	// block after it, and since the head is after ts that block exists.
+	next, err := f.search(ctx, ts+1, head)
+	if err != nil {
+		return nil, err
+	}
+	if next.Number.Sign() == 0 {
+		return nil, ErrBeforeGenesis
+	}
+	return f.header(ctx, next.Number.Uint64()-1)
+}
+
+// search returns the first block with a timestamp at or after ts, knowing
+// that head satisfies it.
+func (f *Finder) search(ctx context.Context, ts uint64, head *types.Header) (*types.Header, error) {
+	f.store(head)
+
+	lo, err := f.header(ctx, 0)
+	if err != nil {
+		return nil, err
+	}
+	if lo.Time >= ts {
+		return lo, nil
+	}
+	hi := head
+
+	// Invariant: lo.Time < ts <= hi.Time, so the answer is in (lo, hi].
+	for step := 0; hi.Number.Uint64()-lo.Number.Uint64() > 1; step++ {
+		loN, hiN := lo.Number.Uint64(), hi.Number.Uint64()
+
+		var mid uint64
+		if step%2 == 0 && hi.Time > lo.Time {
+			// Interpolate assuming a constant block time between lo and hi.
+			// Done with big.Int since blocks*seconds can overflow uint64
+			// on chains with sub-second blocks.
+			offset := new(big.Int).SetUint64(ts - lo.Time)
+			offset.Mul(offset, new(big.Int).SetUint64(hiN-loN))
+			offset.Div(offset, new(big.Int).SetUint64(hi.Time-lo.Time))
+			mid = loN + offset.Uint64()
+		} else {
+			mid = loN + (hiN-loN)/2
+		}
+		if mid <= loN {
+			mid = loN + 1
+		}
+		if mid >= hiN {
+			mid = hiN - 1
+		}
+
+		h, err := f.header(ctx, mid)
+		if err != nil {
+			return nil, err
+		}
+		if h.Time < ts {
+			lo = h
+		} else {
+			hi = h
+		}
+	}
+	return hi, nil
+}
+
+// header returns the header of the given block, from the cache if possible.
+func (f *Finder) header(ctx context.Context, number uint64) (*types.Header, error) {
+	f.mu.Lock()
+	h, ok := f.cache[number]
+	f.mu.Unlock()
+	if ok {
+		return h, nil
+	}
+
+	h, err := f.reader.HeaderByNumber(ctx, new(big.Int).SetUint64(number))
+	if err != nil {
+		return nil, err
+	}
+	f.store(h)
+	return h, nil
+}
+
+// store adds a header to the cache, evicting the oldest entry when full.
+func (f *Finder) store(h *types.Header) {
+	number := h.Number.Uint64()
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.cache[number]; ok {
+		f.cache[number] = h
+		return
+	}
+	if len(f.order) >= f.size {
+		delete(f.cache, f.order[0])
+		f.order = f.order[1:]
+	}
+	f.cache[number] = h
+	f.order = append(f.order, number)
+}
+
+// unix converts t to a block timestamp, clamping times before 1970.
+func unix(t time.Time) uint64 {
+	if s := t.Unix(); s > 0 {
+		return uint64(s)
+	}
+	return 0
+}
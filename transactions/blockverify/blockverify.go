@@ -0,0 +1,154 @@
+// Package blockverify checks that the block data returned by a JSON-RPC
+// provider is consistent with its own header.
+//
+// An RPC endpoint is trusted to hand us the header, the transactions and the
+// receipts of a block, but all three can be checked locally: the block hash
+// is the Keccak-256 of the RLP encoded header, and the header commits to the
+// transaction list and to the receipt list through the roots of two
+// Merkle-Patricia tries. Rebuilding those tries from what the node returned
+// and comparing them to the header catches a provider that lies about any
+// one of them.
+package blockverify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/ethereum/go-ethereum/trie"
+)
+
+var (
+	// ErrHeaderHash is returned when the hash reported by the node does not
+	// match the Keccak-256 of the RLP encoded header.
+	ErrHeaderHash = errors.New("header hash mismatch")
+
+	// ErrTxRoot is returned when the transaction trie rebuilt from the block
+	// body does not match the header's transactions root.
+	ErrTxRoot = errors.New("transactions root mismatch")
+
+	// ErrReceiptRoot is returned when the receipt trie rebuilt from the
+	// transaction receipts does not match the header's receipts root.
+	ErrReceiptRoot = errors.New("receipts root mismatch")
+)
+
+// Report holds the values reported by the node next to the values
+// recomputed locally for a single block.
+type Report struct {
+	Number *big.Int
+
+	ReportedHash common.Hash // "hash" field of eth_getBlockByNumber
+	ComputedHash common.Hash // keccak256(rlp(header))
+
+	TxRoot         common.Hash // header.TxHash
+	ComputedTxRoot common.Hash // root of the rebuilt transaction trie
+
+	ReceiptRoot         common.Hash // header.ReceiptHash
+	ComputedReceiptRoot common.Hash // root of the rebuilt receipt trie
+}
+
+// Err returns the first mismatch found in the report, or nil if the block
+// data is consistent with its header.
+func (r *Report) Err() error {
+	switch {
+	case r.ReportedHash != r.ComputedHash:
+		return fmt.Errorf("block %v: %v: reported %v, computed %v", r.Number, ErrHeaderHash, r.ReportedHash.Hex(), r.ComputedHash.Hex())
+	case r.TxRoot != r.ComputedTxRoot:
+		return fmt.Errorf("block %v: %v: header %v, computed %v", r.Number, ErrTxRoot, r.TxRoot.Hex(), r.ComputedTxRoot.Hex())
+	case r.ReceiptRoot != r.ComputedReceiptRoot:
+		return fmt.Errorf("block %v: %v: header %v, computed %v", r.Number, ErrReceiptRoot, r.ReceiptRoot.Hex(), r.ComputedReceiptRoot.Hex())
+	}
+	return nil
+}
+
+// Verifier fetches blocks and receipts from a node and checks them against
+// the block header.
+type Verifier struct {
+	rpc    *rpc.Client
+	client *ethclient.Client
+}
+
+// NewVerifier returns a Verifier using the given RPC connection.
+func NewVerifier(c *rpc.Client) *Verifier {
+	return &Verifier{rpc: c, client: ethclient.NewClient(c)}
+}
+
+// VerifyBlock fetches the block with the given number (nil for the latest
+// block) together with all of its receipts and returns a report comparing
+// the header against the locally recomputed hash and trie roots. A non-nil
+// error is only returned when the data could not be fetched; use Report.Err
+// to find out whether the block verified.
+func (v *Verifier) VerifyBlock(ctx context.Context, number *big.Int) (*Report, error) {
+	// ethclient recomputes the block hash from the header and throws away
+	// the "hash" field sent by the node, so ask for it separately. The hash
+	// is then used to fetch the body, so that both answers are guaranteed to
+	// be about the same block even if the head moves in between.
+	var reported struct {
+		Hash   common.Hash  `json:"hash"`
+		Number *hexutil.Big `json:"number"`
+	}
+	if err := v.rpc.CallContext(ctx, &reported, "eth_getBlockByNumber", toBlockNumArg(number), false); err != nil {
+		return nil, err
+	}
+	if reported.Number == nil {
+		return nil, fmt.Errorf("block %v not found", number)
+	}
+
+	block, err := v.client.BlockByHash(ctx, reported.Hash)
+	if err != nil {
+		return nil, err
+	}
+
+	header := block.Header()
+	enc, err := rlp.EncodeToBytes(header)
+	if err != nil {
+		return nil, err
+	}
+
+	receipts, err := v.receipts(ctx, reported.Hash, block.Transactions())
+	if err != nil {
+		return nil, err
+	}
+
+	return &Report{
+		Number:              (*big.Int)(reported.Number),
+		ReportedHash:        reported.Hash,
+		ComputedHash:        crypto.Keccak256Hash(enc),
+		TxRoot:              header.TxHash,
+		ComputedTxRoot:      types.DeriveSha(block.Transactions(), trie.NewStackTrie(nil)),
+		ReceiptRoot:         header.ReceiptHash,
+		ComputedReceiptRoot: types.DeriveSha(receipts, trie.NewStackTrie(nil)),
+	}, nil
+}
+
+// receipts fetches the receipt of every transaction in the block, making
+// sure that each one was actually included in the block being verified.
+func (v *Verifier) receipts(ctx context.Context, blockHash common.Hash, txs types.Transactions) (types.Receipts, error) {
+	receipts := make(types.Receipts, len(txs))
+	for i, tx := range txs {
+		receipt, err := v.client.TransactionReceipt(ctx, tx.Hash())
+		if err != nil {
+			return nil, fmt.Errorf("receipt %d (%v): %v", i, tx.Hash().Hex(), err)
+		}
+		if receipt.BlockHash != blockHash {
+			return nil, fmt.Errorf("receipt %d (%v) belongs to block %v", i, tx.Hash().Hex(), receipt.BlockHash.Hex())
+		}
+		receipts[i] = receipt
+	}
+	return receipts, nil
+}
+
+func toBlockNumArg(number *big.Int) string {
+	if number == nil {
+		return "latest"
+	}
+	return hexutil.EncodeBig(number)
+}
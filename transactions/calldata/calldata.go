@@ -0,0 +1,337 @@
+// Package calldata decodes transaction input into a function name and named
+// arguments.
+//
+// The first four bytes of a contract call are the method ID, the first four
+// bytes of the Keccak-256 hash of the function signature, and the rest are
+// the ABI encoded arguments. A Registry maps method IDs back to functions,
+// either from full contract ABIs, which give argument names, or from a table
+// of bare signatures such as the ones published by 4byte.directory, which
+// only give argument types.
+package calldata
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	store "ethereum-go-book/smart_contracts/query_sc/contracts"
+	token "ethereum-go-book/smart_contracts/querying_erc20_token/contracts"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// Selector is the 4-byte method ID at the start of contract call data.
+type Selector [4]byte
+
+// SelectorOf returns the selector of a canonical function signature such as
+// "transfer(address,uint256)".
+func SelectorOf(signature string) Selector {
+	var sel Selector
+	copy(sel[:], crypto.Keccak256([]byte(signature))[:4])
+	return sel
+}
+
+// Hex returns the 0x-prefixed hex encoding of the selector.
+func (s Selector) Hex() string {
+	return hexutil.Encode(s[:])
+}
+
+// Arg is a single decoded argument.
+type Arg struct {
+	Name  string // empty when only the signature is known
+	Type  string
+	Value interface{}
+}
+
+// Call is the decoded input of a transaction.
+type Call struct {
+	Selector  Selector
+	Contract  string // name of the ABI the method was found in, if any
+	Method    string // empty when the selector is unknown
+	Signature string
+	Args      []Arg
+	Raw       []byte // undecoded input, set when the arguments could not be decoded
+}
+
+// Known reports whether the selector was found in the registry.
+func (c *Call) Known() bool {
+	return c.Method != ""
+}
+
+// String formats the call as "name(argName: value, ...)", falling back to the
+// signature or the bare selector when less is known.
+func (c *Call) String() string {
+	if !c.Known() {
+		return fmt.Sprintf("%s (unknown selector)", c.Selector.Hex())
+	}
+	if c.Args == nil {
+		return fmt.Sprintf("%s (undecoded input %s)", c.Signature, hexutil.Encode(c.Raw))
+	}
+	args := make([]string, len(c.Args))
+	for i, arg := range c.Args {
+		name := arg.Name
+		if name == "" {
+			name = arg.Type
+		}
+		args[i] = fmt.Sprintf("%s: %s", name, FormatValue(arg.Value))
+	}
+	return fmt.Sprintf("%s(%s)", c.Method, strings.Join(args, ", "))
+}
+
+// method is a registry entry. Methods registered from a bare signature have
+// no contract name and no argument names.
+type method struct {
+	contract  string
+	name      string
+	signature string
+	inputs    abi.Arguments
+}
+
+// Registry maps selectors to methods. It is safe for concurrent use.
+type Registry struct {
+	mu      sync.RWMutex
+	methods map[Selector]*method
+}
+
+// NewRegistry returns an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{methods: make(map[Selector]*method)}
+}
+
+// Default returns a registry seeded with the Store and ERC20 contract ABIs
+// used throughout this repository.
+func Default() *Registry {
+	r := NewRegistry()
+	for name, def := range map[string]string{
+		"Store": store.StoreABI,
+		"ERC20": token.TokenABI,
+	} {
+		if err := r.AddJSON(name, strings.NewReader(def)); err != nil {
+			panic(fmt.Sprintf("calldata: bad built-in %s ABI: %v", name, err))
+		}
+	}
+	return r
+}
+
+// AddABI registers every method of a parsed contract ABI under the given
+// contract name. Methods registered from a bare signature are replaced so
+// that argument names become available; methods already defined by another
+// ABI are kept.
+func (r *Registry) AddABI(contract string, parsed abi.ABI) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, m := range parsed.Methods {
+		var sel Selector
+		copy(sel[:], m.ID)
+		if old, ok := r.methods[sel]; ok && old.contract != "" {
+			// Keep the first ABI that defined the method.
+			continue
+		}
+		r.methods[sel] = &method{
+			contract:  contract,
+			name:      m.RawName,
+			signature: m.Sig,
+			inputs:    m.Inputs,
+		}
+	}
+}
+
+// AddJSON parses a JSON contract ABI and registers its methods.
+func (r *Registry) AddJSON(contract string, reader io.Reader) error {
+	parsed, err := abi.JSON(reader)
+	if err != nil {
+		return err
+	}
+	r.AddABI(contract, parsed)
+	return nil
+}
+
+// AddABIFile registers the methods of a JSON ABI file, as written by
+// solc --abi, using the file name without extension as the contract name.
+func (r *Registry) AddABIFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	if err := r.AddJSON(name, f); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	return nil
+}
+
+// AddSignature registers a bare function signature such as
+// "transfer(address,uint256)". Signatures never replace methods registered
+// from an ABI.
+func (r *Registry) AddSignature(signature string) error {
+	name, inputs, err := parseSignature(signature)
+	if err != nil {
+		return err
+	}
+	sel := SelectorOf(signature)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.methods[sel]; !ok {
+		r.methods[sel] = &method{name: name, signature: signature, inputs: inputs}
+	}
+	return nil
+}
+
+// AddSelectors reads a selector table with one "0xa9059cbb transfer(address,uint256)"
+// entry per line. Blank lines and lines starting with # are ignored. Each
+// selector is checked against the hash of its signature.
+func (r *Registry) AddSelectors(reader io.Reader) error {
+	scanner := bufio.NewScanner(reader)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) != 2 {
+			return fmt.Errorf("line %d: expected \"<selector> <signature>\"", line)
+		}
+		want, err := hexutil.Decode(fields[0])
+		if err != nil || len(want) != 4 {
+			return fmt.Errorf("line %d: invalid selector %q", line, fields[0])
+		}
+		if sel := SelectorOf(fields[1]); string(sel[:]) != string(want) {
+			return fmt.Errorf("line %d: selector %s does not match %s (%s)", line, fields[0], fields[1], sel.Hex())
+		}
+		if err := r.AddSignature(fields[1]); err != nil {
+			return fmt.Errorf("line %d: %v", line, err)
+		}
+	}
+	return scanner.Err()
+}
+
+// AddSelectorsFile reads a selector table from a file, see AddSelectors.
+func (r *Registry) AddSelectorsFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := r.AddSelectors(f); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	return nil
+}
+
+// Lookup returns the signature registered for a selector.
+func (r *Registry) Lookup(sel Selector) (string, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	m, ok := r.methods[sel]
+	if !ok {
+		return "", false
+	}
+	return m.signature, true
+}
+
+// Decode decodes transaction input. It returns nil for empty input, which is
+// a plain value transfer. Input shorter than a selector is an error. An
+// unknown selector is not an error: the returned call only carries the
+// selector. Arguments that fail to decode against a known method leave
+// Call.Args nil and the input in Call.Raw.
+func (r *Registry) Decode(data []byte) (*Call, error) {
+	if len(data) == 0 {
+		return nil, nil
+	}
+	if len(data) < 4 {
+		return nil, fmt.Errorf("input too short for a selector: %s", hexutil.Encode(data))
+	}
+
+	call := new(Call)
+	copy(call.Selector[:], data[:4])
+
+	r.mu.RLock()
+	m, ok := r.methods[call.Selector]
+	r.mu.RUnlock()
+	if !ok {
+		call.Raw = data
+		return call, nil
+	}
+
+	call.Contract = m.contract
+	call.Method = m.name
+	call.Signature = m.signature
+
+	values, err := m.inputs.Unpack(data[4:])
+	if err != nil {
+		call.Raw = data
+		return call, nil
+	}
+	call.Args = make([]Arg, len(values))
+	for i, value := range values {
+		call.Args[i] = Arg{
+			Name:  m.inputs[i].Name,
+			Type:  m.inputs[i].Type.String(),
+			Value: value,
+		}
+	}
+	return call, nil
+}
+
+// FormatValue formats a decoded ABI value for display: addresses in their
+// checksummed form, byte strings in hex and integers in decimal.
+func FormatValue(value interface{}) string {
+	switch v := value.(type) {
+	case common.Address:
+		return v.Hex()
+	case common.Hash:
+		return v.Hex()
+	case []byte:
+		return hexutil.Encode(v)
+	case [32]byte:
+		return hexutil.Encode(v[:])
+	case *big.Int:
+		return v.String()
+	case string:
+		return fmt.Sprintf("%q", v)
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
+
+// parseSignature splits "name(type1,type2)" into the name and unnamed ABI
+// arguments. Tuple arguments are not supported since their components have
+// no names to build a Go type from.
+func parseSignature(signature string) (string, abi.Arguments, error) {
+	open := strings.IndexByte(signature, '(')
+	if open <= 0 || !strings.HasSuffix(signature, ")") {
+		return "", nil, fmt.Errorf("invalid signature %q", signature)
+	}
+	name, params := signature[:open], signature[open+1:len(signature)-1]
+	if params == "" {
+		return name, abi.Arguments{}, nil
+	}
+	if strings.ContainsAny(params, "() ") {
+		return "", nil, fmt.Errorf("unsupported signature %q", signature)
+	}
+
+	var inputs abi.Arguments
+	for _, t := range strings.Split(params, ",") {
+		typ, err := abi.NewType(t, "", nil)
+		if err != nil {
+			return "", nil, fmt.Errorf("signature %q: %v", signature, err)
+		}
+		inputs = append(inputs, abi.Argument{Type: typ})
+	}
+	return name, inputs, nil
+}
@@ -0,0 +1,145 @@
+// Package calltrace runs the callTracer over a transaction and renders the
+// resulting tree of internal calls.
+//
+// A transaction receipt only tells whether the outermost call succeeded.
+// debug_traceTransaction with the built-in callTracer re-executes the
+// transaction on the node and reports every message call, contract creation
+// and self-destruct it made, with its own value, gas and error. Only nodes
+// that expose the debug namespace, and keep the state of the block, can
+// answer it.
+package calltrace
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/big"
+	"strings"
+
+	"ethereum-go-book/transactions/calldata"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// Frame is a single call as reported by the callTracer.
+type Frame struct {
+	Type         string          `json:"type"` // CALL, STATICCALL, DELEGATECALL, CREATE, SELFDESTRUCT...
+	From         common.Address  `json:"from"`
+	To           *common.Address `json:"to,omitempty"`
+	Value        *hexutil.Big    `json:"value,omitempty"`
+	Gas          hexutil.Uint64  `json:"gas"`
+	GasUsed      hexutil.Uint64  `json:"gasUsed"`
+	Input        hexutil.Bytes   `json:"input"`
+	Output       hexutil.Bytes   `json:"output,omitempty"`
+	Error        string          `json:"error,omitempty"`
+	RevertReason string          `json:"revertReason,omitempty"`
+	Calls        []*Frame        `json:"calls,omitempty"`
+}
+
+// Failed reports whether the call reverted or otherwise failed.
+func (f *Frame) Failed() bool {
+	return f.Error != ""
+}
+
+// Reason returns the revert reason of a failed call, decoded from the
+// Error(string) return data if the node did not decode it already.
+func (f *Frame) Reason() string {
+	if f.RevertReason != "" {
+		return f.RevertReason
+	}
+	if reason, err := abi.UnpackRevert(f.Output); err == nil {
+		return reason
+	}
+	return ""
+}
+
+// Trace runs debug_traceTransaction with the callTracer and returns the
+// outermost call.
+func Trace(ctx context.Context, c *rpc.Client, hash common.Hash) (*Frame, error) {
+	config := map[string]interface{}{
+		"tracer": "callTracer",
+	}
+	var root *Frame
+	if err := c.CallContext(ctx, &root, "debug_traceTransaction", hash, config); err != nil {
+		return nil, err
+	}
+	if root == nil {
+		return nil, fmt.Errorf("no trace for transaction %v", hash.Hex())
+	}
+	return root, nil
+}
+
+// Render writes the call tree rooted at f to w, one call per line with its
+// details indented underneath. Inputs are decoded with registry when it
+// knows the selector; registry may be nil.
+func Render(w io.Writer, f *Frame, registry *calldata.Registry) error {
+	return render(w, f, registry, "", "")
+}
+
+func render(w io.Writer, f *Frame, registry *calldata.Registry, first, rest string) error {
+	to := "(none)"
+	if f.To != nil {
+		to = f.To.Hex()
+	}
+	line := fmt.Sprintf("%s%s %s -> %s", first, f.Type, f.From.Hex(), to)
+	if value := (*big.Int)(f.Value); value != nil && value.Sign() > 0 {
+		line += fmt.Sprintf(" value=%v", value)
+	}
+	line += fmt.Sprintf(" gas=%d gasUsed=%d", uint64(f.Gas), uint64(f.GasUsed))
+	if _, err := fmt.Fprintln(w, line); err != nil {
+		return err
+	}
+
+	// Details are printed under the call, lined up with its children.
+	detail := rest + "│   "
+	if len(f.Calls) == 0 {
+		detail = rest + "    "
+	}
+	if input := describeInput(f, registry); input != "" {
+		if _, err := fmt.Fprintf(w, "%sinput: %s\n", detail, input); err != nil {
+			return err
+		}
+	}
+	if f.Failed() {
+		msg := f.Error
+		if reason := f.Reason(); reason != "" {
+			msg += ": " + reason
+		}
+		if _, err := fmt.Fprintf(w, "%serror: %s\n", detail, msg); err != nil {
+			return err
+		}
+	}
+
+	for i, call := range f.Calls {
+		branch, indent := "├── ", "│   "
+		if i == len(f.Calls)-1 {
+			branch, indent = "└── ", "    "
+		}
+		if err := render(w, call, registry, rest+branch, rest+indent); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// describeInput returns a short description of the call input, or an empty
+// string for calls without input.
+func describeInput(f *Frame, registry *calldata.Registry) string {
+	if len(f.Input) == 0 {
+		return ""
+	}
+	if strings.HasPrefix(f.Type, "CREATE") {
+		return fmt.Sprintf("%d bytes of init code", len(f.Input))
+	}
+	if registry == nil {
+		return hexutil.Encode(f.Input)
+	}
+	call, err := registry.Decode(f.Input)
+	if err != nil || call == nil {
+		return hexutil.Encode(f.Input)
+	}
+	return call.String()
+}
@@ -0,0 +1,184 @@
+package calltrace
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"ethereum-go-book/transactions/calldata"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// cannedTrace is a callTracer result of a router call that deploys a
+// contract, makes a token transfer and then calls a contract that reverts.
+const cannedTrace = `{
+	"type": "CALL",
+	"from": "0x96216849c49358b10257cb55b28ea603c874b05e",
+	"to": "0x35386c483387b87d87eafc0f35504e9539a0b8f2",
+	"value": "0xde0b6b3a7640000",
+	"gas": "0x30d40",
+	"gasUsed": "0x1d4c0",
+	"input": "0x12345678",
+	"error": "execution reverted",
+	"calls": [
+		{
+			"type": "CREATE",
+			"from": "0x35386c483387b87d87eafc0f35504e9539a0b8f2",
+			"to": "0x1111111111111111111111111111111111111111",
+			"gas": "0x10000",
+			"gasUsed": "0x8000",
+			"input": "0x6080604052"
+		},
+		{
+			"type": "CALL",
+			"from": "0x35386c483387b87d87eafc0f35504e9539a0b8f2",
+			"to": "0x28b149020d2152179873ec60bed6bf7cd705775d",
+			"gas": "0x8000",
+			"gasUsed": "0x5208",
+			"input": "0xa9059cbb0000000000000000000000004592d8f8d7b001e72cb26a73e4fa1806a51ac79d00000000000000000000000000000000000000000000000000000000000003e8",
+			"output": "0x0000000000000000000000000000000000000000000000000000000000000001"
+		},
+		{
+			"type": "STATICCALL",
+			"from": "0x35386c483387b87d87eafc0f35504e9539a0b8f2",
+			"to": "0x2222222222222222222222222222222222222222",
+			"gas": "0x4000",
+			"gasUsed": "0x1000",
+			"input": "0x70a08231",
+			"error": "execution reverted",
+			"output": "0x08c379a000000000000000000000000000000000000000000000000000000000000000200000000000000000000000000000000000000000000000000000000000000014696e73756666696369656e742062616c616e6365000000000000000000000000"
+		}
+	]
+}`
+
+// newServer starts a stand-in JSON-RPC server answering
+// debug_traceTransaction with result, and returns a client connected to it.
+func newServer(t *testing.T, result string) *rpc.Client {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			ID     json.RawMessage   `json:"id"`
+			Method string            `json:"method"`
+			Params []json.RawMessage `json:"params"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if req.Method != "debug_traceTransaction" || len(req.Params) != 2 || !bytes.Contains(req.Params[1], []byte(`"callTracer"`)) {
+			t.Errorf("unexpected request %s %s", req.Method, req.Params)
+			w.Write([]byte(`{"jsonrpc":"2.0","id":` + string(req.ID) + `,"error":{"code":-32601,"message":"unexpected request"}}`))
+			return
+		}
+		w.Write([]byte(`{"jsonrpc":"2.0","id":` + string(req.ID) + `,"result":` + result + `}`))
+	}))
+	t.Cleanup(server.Close)
+
+	client, err := rpc.Dial(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(client.Close)
+	return client
+}
+
+func TestTrace(t *testing.T) {
+	client := newServer(t, cannedTrace)
+	root, err := Trace(context.Background(), client, common.HexToHash("0x01"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if root.Type != "CALL" || root.To == nil || *root.To != common.HexToAddress("0x35386c483387b87d87eafc0f35504e9539a0b8f2") {
+		t.Errorf("root = %s to %v, want CALL to the router", root.Type, root.To)
+	}
+	if v := root.Value.ToInt().String(); v != "1000000000000000000" {
+		t.Errorf("root value = %s, want 1 ether", v)
+	}
+	if uint64(root.Gas) != 200000 || uint64(root.GasUsed) != 120000 {
+		t.Errorf("root gas = %d/%d, want 200000/120000", root.Gas, root.GasUsed)
+	}
+	if len(root.Calls) != 3 {
+		t.Fatalf("root has %d calls, want 3", len(root.Calls))
+	}
+
+	wantTypes := []string{"CREATE", "CALL", "STATICCALL"}
+	wantFailed := []bool{false, false, true}
+	for i, call := range root.Calls {
+		if call.Type != wantTypes[i] {
+			t.Errorf("call %d type = %s, want %s", i, call.Type, wantTypes[i])
+		}
+		if call.Failed() != wantFailed[i] {
+			t.Errorf("call %d failed = %v, want %v", i, call.Failed(), wantFailed[i])
+		}
+	}
+
+	if !root.Failed() {
+		t.Error("root should have failed")
+	}
+	if reason := root.Reason(); reason != "" {
+		t.Errorf("root reason = %q, want none", reason)
+	}
+	if reason := root.Calls[2].Reason(); reason != "insufficient balance" {
+		t.Errorf("reverted call reason = %q, want %q", reason, "insufficient balance")
+	}
+}
+
+func TestTraceNotFound(t *testing.T) {
+	client := newServer(t, "null")
+	if _, err := Trace(context.Background(), client, common.HexToHash("0x01")); err == nil {
+		t.Fatal("expected an error for a missing trace")
+	}
+}
+
+func TestRender(t *testing.T) {
+	client := newServer(t, cannedTrace)
+	root, err := Trace(context.Background(), client, common.HexToHash("0x01"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	registry := calldata.NewRegistry()
+	if err := registry.AddSignature("transfer(address,uint256)"); err != nil {
+		t.Fatal(err)
+	}
+	var out strings.Builder
+	if err := Render(&out, root, registry); err != nil {
+		t.Fatal(err)
+	}
+	text := out.String()
+
+	sender := common.HexToAddress("0x96216849c49358b10257cb55b28ea603c874b05e")
+	recipient := common.HexToAddress("0x4592d8f8d7b001e72cb26a73e4fa1806a51ac79d")
+	for _, want := range []string{
+		"CALL " + sender.Hex() + " -> ",
+		"value=1000000000000000000 gas=200000 gasUsed=120000",
+		"input: 0x12345678 (unknown selector)",
+		"├── CREATE ",
+		"input: 5 bytes of init code",
+		"├── CALL ",
+		"input: transfer(address: " + recipient.Hex() + ", uint256: 1000)",
+		"└── STATICCALL ",
+		"error: execution reverted: insufficient balance",
+	} {
+		if !strings.Contains(text, want) {
+			t.Errorf("rendered trace lacks %q:\n%s", want, text)
+		}
+	}
+
+	// Without a registry inputs are shown in hex.
+	out.Reset()
+	if err := Render(&out, root, nil); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "input: 0xa9059cbb0000") {
+		t.Errorf("rendered trace without registry lacks the raw transfer input:\n%s", out.String())
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+/*
+
+  Chain Health Metrics
+
+  The block subscriber prints what it sees and forgets it. To alert on a
+  stalled node we want to keep a few numbers around and let Prometheus scrape
+  them: the head height, how long ago the last block was mined, how far apart
+  blocks are, how full they are, the base fee, how often the subscription had
+  to reconnect and how long the node takes to answer each RPC method.
+
+  $ go run chain_metrics.go -rpc wss://mainnet.infura.io/ws \
+      -http https://mainnet.infura.io -listen localhost:9300
+
+  $ curl localhost:9300/metrics
+
+  Each new head can also be sent to sinks, with -sink as in the block
+  subscriber.
+
+*/
+import (
+	"context"
+	"flag"
+	"log"
+	"net/http"
+
+	"ethereum-go-book/transactions/chainmetrics"
+	"ethereum-go-book/transactions/heads"
+	"ethereum-go-book/transactions/sinks"
+
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+func main() {
+	url := flag.String("rpc", "wss://mainnet.infura.io/ws", "endpoint to follow new heads on")
+	httpURL := flag.String("http", "https://mainnet.infura.io", "HTTP endpoint for all other requests")
+	listen := flag.String("listen", "localhost:9300", "address to serve /metrics on")
+	sinkSpecs := flag.String("sink", "", "comma separated sinks: stdout, file:PATH or a webhook URL")
+	secret := flag.String("webhook-secret", "", "key to sign webhook requests with (HMAC-SHA256)")
+	flag.Parse()
+
+	metrics := chainmetrics.New()
+
+	sink, err := sinks.Open(*sinkSpecs, *secret)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer sink.Close()
+
+	// Every request sent over HTTP goes through an instrumented transport,
+	// which times it under its JSON-RPC method name.
+
+	httpClient := &http.Client{Transport: metrics.Transport(nil)}
+	rpcClient, err := rpc.DialHTTPWithClient(*httpURL, httpClient)
+	if err != nil {
+		log.Fatal(err)
+	}
+	client := ethclient.NewClient(rpcClient)
+
+	follower := heads.NewFollower(heads.Config{
+		URL:         *url,
+		BackfillURL: *httpURL,
+		HTTPClient:  httpClient,
+		OnReconnect: metrics.IncReconnects,
+	})
+
+	headers := make(chan *types.Header)
+	sub, err := follower.SubscribeNewHead(context.Background(), headers)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer sub.Unsubscribe()
+
+	http.Handle("/metrics", metrics)
+	go func() {
+		log.Fatal(http.ListenAndServe(*listen, nil))
+	}()
+
+	for {
+		select {
+		case err := <-sub.Err():
+			log.Fatal(err)
+		case header := <-headers:
+			count, err := client.TransactionCount(context.Background(), header.Hash())
+			if err != nil {
+				log.Printf("transaction count of block %v: %v", header.Number, err)
+			}
+			metrics.ObserveHead(header, int(count))
+
+			data := map[string]interface{}{
+				"number":       header.Number.Uint64(),
+				"hash":         header.Hash().Hex(),
+				"time":         header.Time,
+				"gasUsed":      header.GasUsed,
+				"gasLimit":     header.GasLimit,
+				"transactions": count,
+			}
+			if header.BaseFee != nil {
+				data["baseFee"] = header.BaseFee.String()
+			}
+			if err := sink.Send(context.Background(), sinks.NewRecord("head", data)); err != nil {
+				log.Printf("sink: %v", err)
+			}
+		}
+	}
+}
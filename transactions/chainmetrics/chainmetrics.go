@@ -0,0 +1,245 @@
+// Package chainmetrics exposes the health of a chain, as seen through a node,
+// in the Prometheus text exposition format.
+//
+// The metrics are fed from the head follower: every delivered header updates
+// the head height, gas usage and base fee gauges and the block interval
+// histogram, and every reconnection bumps a counter. RPC latency is measured
+// per JSON-RPC method by wrapping the HTTP transport used to talk to the
+// node. The time since the last block is computed when scraped, so a stalled
+// node shows up even though no new header arrives.
+package chainmetrics
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+var (
+	blockIntervalBuckets = []float64{1, 2, 5, 10, 12, 15, 20, 30, 60, 120, 300}
+	rpcLatencyBuckets    = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+)
+
+// histogram is a cumulative Prometheus histogram.
+type histogram struct {
+	buckets []float64
+	counts  []uint64 // counts[i] is the number of observations <= buckets[i]
+	count   uint64
+	sum     float64
+}
+
+func newHistogram(buckets []float64) *histogram {
+	return &histogram{buckets: buckets, counts: make([]uint64, len(buckets))}
+}
+
+func (h *histogram) observe(v float64) {
+	for i, le := range h.buckets {
+		if v <= le {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+func (h *histogram) write(w io.Writer, name, labels string) {
+	sep := ""
+	if labels != "" {
+		sep = ","
+	}
+	for i, le := range h.buckets {
+		fmt.Fprintf(w, "%s_bucket{%s%sle=\"%s\"} %d\n", name, labels, sep, formatFloat(le), h.counts[i])
+	}
+	fmt.Fprintf(w, "%s_bucket{%s%sle=\"+Inf\"} %d\n", name, labels, sep, h.count)
+	if labels != "" {
+		labels = "{" + labels + "}"
+	}
+	fmt.Fprintf(w, "%s_sum%s %s\n", name, labels, formatFloat(h.sum))
+	fmt.Fprintf(w, "%s_count%s %d\n", name, labels, h.count)
+}
+
+// Metrics holds the chain health metrics. It is safe for concurrent use and
+// implements http.Handler to serve them.
+type Metrics struct {
+	mu sync.Mutex
+
+	head          *types.Header
+	txCount       int
+	reconnects    uint64
+	blockInterval *histogram
+	rpcLatency    map[string]*histogram
+	rpcErrors     map[string]uint64
+}
+
+// New returns an empty set of metrics.
+func New() *Metrics {
+	return &Metrics{
+		blockInterval: newHistogram(blockIntervalBuckets),
+		rpcLatency:    make(map[string]*histogram),
+		rpcErrors:     make(map[string]uint64),
+	}
+}
+
+// ObserveHead records a new head and the number of transactions in it. The
+// block interval is only observed when header directly follows the previous
+// head, so reorgs and the first header don't skew the histogram.
+func (m *Metrics) ObserveHead(header *types.Header, txCount int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if prev := m.head; prev != nil && header.ParentHash == prev.Hash() && header.Time >= prev.Time {
+		m.blockInterval.observe(float64(header.Time - prev.Time))
+	}
+	m.head = header
+	m.txCount = txCount
+}
+
+// IncReconnects counts a lost subscription. It has the signature of the head
+// follower's OnReconnect hook.
+func (m *Metrics) IncReconnects(attempt int, err error) {
+	m.mu.Lock()
+	m.reconnects++
+	m.mu.Unlock()
+}
+
+// ObserveRPC records the latency of a JSON-RPC call.
+func (m *Metrics) ObserveRPC(method string, d time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	h, ok := m.rpcLatency[method]
+	if !ok {
+		h = newHistogram(rpcLatencyBuckets)
+		m.rpcLatency[method] = h
+	}
+	h.observe(d.Seconds())
+	if err != nil {
+		m.rpcErrors[method]++
+	}
+}
+
+// Transport wraps an HTTP transport so that every JSON-RPC request sent
+// through it is timed by method. Batches are recorded under "batch". A nil
+// base uses http.DefaultTransport.
+func (m *Metrics) Transport(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &transport{base: base, metrics: m}
+}
+
+type transport struct {
+	base    http.RoundTripper
+	metrics *Metrics
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	method := "unknown"
+	if req.Body != nil {
+		body, err := io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		method = rpcMethod(body)
+	}
+
+	start := time.Now()
+	resp, err := t.base.RoundTrip(req)
+	if err == nil && resp.StatusCode != http.StatusOK {
+		t.metrics.ObserveRPC(method, time.Since(start), fmt.Errorf("HTTP %s", resp.Status))
+	} else {
+		t.metrics.ObserveRPC(method, time.Since(start), err)
+	}
+	return resp, err
+}
+
+// rpcMethod extracts the method name of a JSON-RPC request body.
+func rpcMethod(body []byte) string {
+	body = bytes.TrimSpace(body)
+	if len(body) > 0 && body[0] == '[' {
+		return "batch"
+	}
+	var msg struct {
+		Method string `json:"method"`
+	}
+	if err := json.Unmarshal(body, &msg); err != nil || msg.Method == "" {
+		return "unknown"
+	}
+	return msg.Method
+}
+
+// ServeHTTP writes the metrics in the Prometheus text format.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	m.Expose(w)
+}
+
+// Expose writes the metrics in the Prometheus text format.
+func (m *Metrics) Expose(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if head := m.head; head != nil {
+		gauge(w, "chain_head_height", "Number of the latest block.", formatFloat(float64(head.Number.Uint64())))
+		since := time.Since(time.Unix(int64(head.Time), 0)).Seconds()
+		gauge(w, "chain_seconds_since_last_block", "Seconds since the timestamp of the latest block.", formatFloat(since))
+		ratio := 0.0
+		if head.GasLimit > 0 {
+			ratio = float64(head.GasUsed) / float64(head.GasLimit)
+		}
+		gauge(w, "chain_gas_used_ratio", "Gas used over gas limit of the latest block.", formatFloat(ratio))
+		if head.BaseFee != nil {
+			fee, _ := new(big.Float).SetInt(head.BaseFee).Float64()
+			gauge(w, "chain_base_fee_wei", "Base fee per gas of the latest block.", formatFloat(fee))
+		}
+		gauge(w, "chain_block_transactions", "Number of transactions in the latest block.", strconv.Itoa(m.txCount))
+	}
+
+	fmt.Fprintf(w, "# HELP chain_block_interval_seconds Time between consecutive blocks.\n")
+	fmt.Fprintf(w, "# TYPE chain_block_interval_seconds histogram\n")
+	m.blockInterval.write(w, "chain_block_interval_seconds", "")
+
+	fmt.Fprintf(w, "# HELP chain_subscription_reconnects_total Head subscriptions lost and reconnected.\n")
+	fmt.Fprintf(w, "# TYPE chain_subscription_reconnects_total counter\n")
+	fmt.Fprintf(w, "chain_subscription_reconnects_total %d\n", m.reconnects)
+
+	methods := make([]string, 0, len(m.rpcLatency))
+	for method := range m.rpcLatency {
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+
+	fmt.Fprintf(w, "# HELP rpc_request_duration_seconds Latency of JSON-RPC requests by method.\n")
+	fmt.Fprintf(w, "# TYPE rpc_request_duration_seconds histogram\n")
+	for _, method := range methods {
+		m.rpcLatency[method].write(w, "rpc_request_duration_seconds", fmt.Sprintf("method=%q", method))
+	}
+	fmt.Fprintf(w, "# HELP rpc_request_errors_total Failed JSON-RPC requests by method.\n")
+	fmt.Fprintf(w, "# TYPE rpc_request_errors_total counter\n")
+	for _, method := range methods {
+		fmt.Fprintf(w, "rpc_request_errors_total{method=%q} %d\n", method, m.rpcErrors[method])
+	}
+}
+
+func gauge(w io.Writer, name, help, value string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s %s\n", name, help, name, name, value)
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
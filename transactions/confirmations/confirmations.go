@@ -0,0 +1,102 @@
+package main
+
+/*
+
+  Waiting for Confirmations
+
+  A block we just received on the headers channel can still be replaced by
+  a reorg, so acting on it right away (crediting a payment, say) is risky.
+  The usual answer is to wait until a number of blocks were built on top of
+  it. Nodes since the merge can also tell us which blocks are "safe" or
+  "finalized", which is an even stronger guarantee.
+
+  In this section we put a notifier between the head follower and our code:
+  it releases a block once it has enough confirmations, or once the node
+  reports it under the chosen tag, and if a released block gets reorged away
+  anyway it tells us so explicitly.
+
+  $ go run confirmations.go -depth 12 -tag finalized
+
+  Confirmed and retracted blocks can also be sent to sinks, for example a
+  webhook that credits payments:
+
+  $ go run confirmations.go -depth 12 -sink https://example.com/hook -webhook-secret s3cret
+
+*/
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+
+	"ethereum-go-book/transactions/finality"
+	"ethereum-go-book/transactions/heads"
+	"ethereum-go-book/transactions/sinks"
+
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+)
+
+func main() {
+	url := flag.String("rpc", "wss://mainnet.infura.io/ws", "JSON-RPC endpoint")
+	depth := flag.Uint64("depth", 12, "confirmations needed to release a block, 0 to only use the tag")
+	tag := flag.String("tag", "", "also release blocks reported as \"safe\" or \"finalized\"")
+	sinkSpecs := flag.String("sink", "", "comma separated sinks: stdout, file:PATH or a webhook URL")
+	secret := flag.String("webhook-secret", "", "key to sign webhook requests with (HMAC-SHA256)")
+	flag.Parse()
+
+	client, err := ethclient.Dial(*url)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	sink, err := sinks.Open(*sinkSpecs, *secret)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer sink.Close()
+
+	// The notifier expects the headers in the order the head follower
+	// delivers them: no gaps, and a reorg shows up as a header at or below
+	// the current height.
+
+	notifier, err := finality.NewNotifier(client, *depth, *tag)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	follower := heads.NewFollower(heads.Config{URL: *url})
+	headers := make(chan *types.Header)
+	sub, err := follower.SubscribeNewHead(context.Background(), headers)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer sub.Unsubscribe()
+
+	events := make(chan finality.Event)
+	go func() {
+		if err := notifier.Run(context.Background(), headers, sub.Err(), events); err != nil {
+			log.Fatal(err)
+		}
+	}()
+
+	for event := range events {
+		switch event.Kind {
+		case finality.Confirmed:
+			fmt.Printf("\tConfirmed block %v (%v) with %d confirmations, by %s\n",
+				event.Header.Number, event.Header.Hash().Hex(), event.Confirmations, event.Reason)
+		case finality.Retracted:
+			fmt.Printf("\tRetracted block %v (%v)\n", event.Header.Number, event.Header.Hash().Hex())
+		}
+
+		rec := sinks.NewRecord(event.Kind.String(), map[string]interface{}{
+			"number":        event.Header.Number.Uint64(),
+			"hash":          event.Header.Hash().Hex(),
+			"confirmations": event.Confirmations,
+			"reason":        event.Reason,
+		})
+		if err := sink.Send(context.Background(), rec); err != nil {
+			log.Printf("sink: %v", err)
+		}
+	}
+}
@@ -0,0 +1,198 @@
+// Package ens resolves Ethereum Name Service names to addresses and back.
+//
+// Names are looked up in two steps. The registry contract maps the namehash
+// of a name to the resolver contract responsible for it, and the resolver
+// maps the namehash to an address. Reverse records work the same way: the
+// name of an address is set on <address in lowercase hex>.addr.reverse.
+// Anyone can claim any name in a reverse record, so a reverse name is only
+// trusted when the name resolves back to the same address.
+package ens
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"ethereum-go-book/transactions/addresses"
+
+	ethereum "github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// RegistryAddress is where the ENS registry is deployed on mainnet and the
+// public test networks.
+var RegistryAddress = common.HexToAddress("0x00000000000C2E074eC69A0dFb2997BA6C7d2e1e")
+
+// ErrNotFound is returned when a name has no resolver or no address.
+var ErrNotFound = errors.New("ens: name not found")
+
+const registryABI = `[
+	{"constant":true,"inputs":[{"name":"node","type":"bytes32"}],"name":"resolver","outputs":[{"name":"","type":"address"}],"type":"function"}
+]`
+
+const resolverABI = `[
+	{"constant":true,"inputs":[{"name":"node","type":"bytes32"}],"name":"addr","outputs":[{"name":"","type":"address"}],"type":"function"},
+	{"constant":true,"inputs":[{"name":"node","type":"bytes32"}],"name":"name","outputs":[{"name":"","type":"string"}],"type":"function"}
+]`
+
+// Namehash computes the EIP-137 namehash of a name: the hash of the empty
+// name is zero, and each label, from the right, is hashed into its parent.
+// Names are lowercased; the full UTS-46 normalisation is not applied, so
+// names outside ASCII may hash differently than in other clients.
+func Namehash(name string) common.Hash {
+	var node common.Hash
+	name = strings.ToLower(name)
+	if name == "" {
+		return node
+	}
+	labels := strings.Split(name, ".")
+	for i := len(labels) - 1; i >= 0; i-- {
+		label := crypto.Keccak256Hash([]byte(labels[i]))
+		node = crypto.Keccak256Hash(node[:], label[:])
+	}
+	return node
+}
+
+// Resolver resolves names through an ENS registry.
+type Resolver struct {
+	caller   ethereum.ContractCaller
+	registry common.Address
+	regABI   abi.ABI
+	resABI   abi.ABI
+}
+
+// NewResolver returns a resolver using the registry at RegistryAddress.
+func NewResolver(caller ethereum.ContractCaller) (*Resolver, error) {
+	return NewResolverAt(caller, RegistryAddress)
+}
+
+// NewResolverAt returns a resolver using the registry at the given address,
+// for chains where ENS is deployed elsewhere.
+func NewResolverAt(caller ethereum.ContractCaller, registry common.Address) (*Resolver, error) {
+	regABI, err := abi.JSON(strings.NewReader(registryABI))
+	if err != nil {
+		return nil, err
+	}
+	resABI, err := abi.JSON(strings.NewReader(resolverABI))
+	if err != nil {
+		return nil, err
+	}
+	return &Resolver{caller: caller, registry: registry, regABI: regABI, resABI: resABI}, nil
+}
+
+// Resolve returns the address a name points to.
+func (r *Resolver) Resolve(ctx context.Context, name string) (common.Address, error) {
+	if err := checkName(name); err != nil {
+		return common.Address{}, err
+	}
+	node := Namehash(name)
+	resolver, err := r.resolver(ctx, node)
+	if err != nil {
+		return common.Address{}, err
+	}
+
+	var addr common.Address
+	if err := r.call(ctx, r.resABI, resolver, "addr", node, &addr); err != nil {
+		return common.Address{}, fmt.Errorf("ens: resolving %s: %v", name, err)
+	}
+	if addr == (common.Address{}) {
+		return common.Address{}, ErrNotFound
+	}
+	return addr, nil
+}
+
+// Reverse returns the primary name of an address, or "" if it has none or
+// its name doesn't resolve back to it.
+func (r *Resolver) Reverse(ctx context.Context, addr common.Address) (string, error) {
+	node := Namehash(strings.ToLower(addr.Hex()[2:]) + ".addr.reverse")
+	resolver, err := r.resolver(ctx, node)
+	if err == ErrNotFound {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	var name string
+	if err := r.call(ctx, r.resABI, resolver, "name", node, &name); err != nil {
+		return "", fmt.Errorf("ens: reverse lookup of %s: %v", addr.Hex(), err)
+	}
+	if name == "" {
+		return "", nil
+	}
+
+	forward, err := r.Resolve(ctx, name)
+	if err == ErrNotFound || (err == nil && forward != addr) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+// Display returns "name (address)" when the address has a verified reverse
+// record, and the checksummed address alone otherwise, including when the
+// lookup fails.
+func (r *Resolver) Display(ctx context.Context, addr common.Address) string {
+	name, err := r.Reverse(ctx, addr)
+	if err != nil || name == "" {
+		return addr.Hex()
+	}
+	return fmt.Sprintf("%s (%s)", name, addr.Hex())
+}
+
+func (r *Resolver) resolver(ctx context.Context, node common.Hash) (common.Address, error) {
+	var resolver common.Address
+	if err := r.call(ctx, r.regABI, r.registry, "resolver", node, &resolver); err != nil {
+		return common.Address{}, fmt.Errorf("ens: registry: %v", err)
+	}
+	if resolver == (common.Address{}) {
+		return common.Address{}, ErrNotFound
+	}
+	return resolver, nil
+}
+
+func (r *Resolver) call(ctx context.Context, contractABI abi.ABI, contract common.Address, method string, node common.Hash, out interface{}) error {
+	data, err := contractABI.Pack(method, node)
+	if err != nil {
+		return err
+	}
+	result, err := r.caller.CallContract(ctx, ethereum.CallMsg{To: &contract, Data: data}, nil)
+	if err != nil {
+		return err
+	}
+	if len(result) == 0 {
+		return errors.New("no contract at " + contract.Hex())
+	}
+	return contractABI.UnpackIntoInterface(out, method, result)
+}
+
+// IsName reports whether s looks like an ENS name rather than an address.
+func IsName(s string) bool {
+	return strings.Contains(s, ".") && !strings.HasPrefix(s, "0x")
+}
+
+func checkName(name string) error {
+	if name == "" || strings.HasPrefix(name, ".") || strings.HasSuffix(name, ".") || strings.Contains(name, "..") {
+		return fmt.Errorf("ens: invalid name %q", name)
+	}
+	if strings.ContainsAny(name, " \t\n") {
+		return fmt.Errorf("ens: invalid name %q", name)
+	}
+	return nil
+}
+
+// Lookup turns a recipient given on the command line into an address: ENS
+// names are resolved, anything else must be a hex address. The checksum of
+// a mixed case address is checked as in addresses.ParseForChain.
+func (r *Resolver) Lookup(ctx context.Context, recipient string, checksumChain *big.Int) (common.Address, error) {
+	if IsName(recipient) {
+		return r.Resolve(ctx, recipient)
+	}
+	return addresses.ParseForChain(recipient, checksumChain)
+}
@@ -0,0 +1,110 @@
+package ens
+
+import (
+	"context"
+	"math/big"
+	"strings"
+	"testing"
+
+	ethereum "github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Test vectors from EIP-137.
+func TestNamehash(t *testing.T) {
+	tests := []struct {
+		name string
+		hash string
+	}{
+		{"", "0x0000000000000000000000000000000000000000000000000000000000000000"},
+		{"eth", "0x93cdeb708b7545dc668eb9280176169d1c33cfd8ed6f04690a0bcc88a93fc4ae"},
+		{"foo.eth", "0xde9b09fd7c5f901e23a3f19fecc54828e9c848539801e86591bd9801b019f84f"},
+		{"Foo.ETH", "0xde9b09fd7c5f901e23a3f19fecc54828e9c848539801e86591bd9801b019f84f"},
+	}
+	for _, test := range tests {
+		if got := Namehash(test.name).Hex(); got != test.hash {
+			t.Errorf("Namehash(%q) = %s, want %s", test.name, got, test.hash)
+		}
+	}
+}
+
+// stubCaller answers contract calls from canned results, keyed by contract
+// address and call data.
+type stubCaller struct {
+	results map[string][]byte
+}
+
+func (s *stubCaller) CodeAt(ctx context.Context, contract common.Address, blockNumber *big.Int) ([]byte, error) {
+	return nil, nil
+}
+
+func (s *stubCaller) CallContract(ctx context.Context, msg ethereum.CallMsg, blockNumber *big.Int) ([]byte, error) {
+	return s.results[msg.To.Hex()+common.Bytes2Hex(msg.Data)], nil
+}
+
+// set makes calls of method on contract with node return out.
+func (s *stubCaller) set(t *testing.T, contractABI string, contract common.Address, method string, node common.Hash, out interface{}) {
+	t.Helper()
+	parsed, err := abi.JSON(strings.NewReader(contractABI))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := parsed.Pack(method, node)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := parsed.Methods[method].Outputs.Pack(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.results[contract.Hex()+common.Bytes2Hex(data)] = result
+}
+
+func TestReverse(t *testing.T) {
+	var (
+		registry = common.HexToAddress("0x1000000000000000000000000000000000000001")
+		resolver = common.HexToAddress("0x2000000000000000000000000000000000000002")
+		owner    = common.HexToAddress("0x96216849c49358b10257cb55b28ea603c874b05e")
+		other    = common.HexToAddress("0x4592d8f8d7b001e72cb26a73e4fa1806a51ac79d")
+	)
+	reverseNode := Namehash(strings.ToLower(owner.Hex()[2:]) + ".addr.reverse")
+	nameNode := Namehash("alice.eth")
+
+	// The reverse record of owner claims alice.eth, and alice.eth has a
+	// resolver whose address record is set per test.
+	caller := &stubCaller{results: make(map[string][]byte)}
+	caller.set(t, registryABI, registry, "resolver", reverseNode, resolver)
+	caller.set(t, resolverABI, resolver, "name", reverseNode, "alice.eth")
+	caller.set(t, registryABI, registry, "resolver", nameNode, resolver)
+
+	r, err := NewResolverAt(caller, registry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	caller.set(t, resolverABI, resolver, "addr", nameNode, owner)
+	if name, err := r.Reverse(ctx, owner); err != nil || name != "alice.eth" {
+		t.Errorf("Reverse = %q, %v, want alice.eth", name, err)
+	}
+	if got, want := r.Display(ctx, owner), "alice.eth ("+owner.Hex()+")"; got != want {
+		t.Errorf("Display = %q, want %q", got, want)
+	}
+
+	// alice.eth now resolves to another address, so the claim is ignored.
+	caller.set(t, resolverABI, resolver, "addr", nameNode, other)
+	if name, err := r.Reverse(ctx, owner); err != nil || name != "" {
+		t.Errorf("Reverse of an unverified name = %q, %v, want none", name, err)
+	}
+	if got := r.Display(ctx, owner); got != owner.Hex() {
+		t.Errorf("Display of an unverified name = %q, want %s", got, owner.Hex())
+	}
+
+	// An address without a reverse record has no name either.
+	otherNode := Namehash(strings.ToLower(other.Hex()[2:]) + ".addr.reverse")
+	caller.set(t, registryABI, registry, "resolver", otherNode, common.Address{})
+	if name, err := r.Reverse(ctx, other); err != nil || name != "" {
+		t.Errorf("Reverse without a record = %q, %v, want none", name, err)
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+/*
+
+  Transaction Fee Report
+
+  The gas price of a transaction is only what the sender offered. Since the
+  London fork the fee actually paid depends on the block's base fee, which is
+  burnt, and only the priority tip goes to the block's fee recipient. This
+  section walks a range of blocks and reports, per transaction, per block and
+  per sender, what was paid, burnt and tipped, and what each fee recipient
+  received in tips.
+
+  $ go run fee_report.go -from 12965000 -to 12965002
+
+*/
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"sort"
+
+	"ethereum-go-book/transactions/fees"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+func main() {
+	url := flag.String("rpc", "https://mainnet.infura.io", "JSON-RPC endpoint")
+	from := flag.Uint64("from", 12965000, "first block of the range")
+	to := flag.Uint64("to", 12965000, "last block of the range")
+	verbose := flag.Bool("v", false, "print every transaction")
+	flag.Parse()
+
+	client, err := rpc.Dial(*url)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	analyzer, err := fees.NewAnalyzer(context.Background(), client)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	report, err := analyzer.Range(context.Background(), *from, *to)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	for _, block := range report.Blocks {
+		fmt.Printf("\nBlock %d\n", block.Number)
+		if block.BaseFee != nil {
+			fmt.Printf("\tBase Fee: %v wei\n", block.BaseFee)
+		}
+		fmt.Printf("\tGas Used: %d / %d (%.2f%%)\n", block.GasUsed, block.GasLimit, 100*block.GasUsedRatio())
+		fmt.Printf("\tTransactions: %d\n", block.Totals.Txs)
+		fmt.Printf("\tFees Paid: %v wei\n", block.Totals.Fee)
+		fmt.Printf("\tBurnt: %v wei\n", block.Totals.Burnt)
+		fmt.Printf("\tTips to %v: %v wei\n", block.FeeRecipient.Hex(), block.Totals.Tip)
+
+		if !*verbose {
+			continue
+		}
+		for _, tx := range block.Txs {
+			fmt.Printf("\n\t\tTx Hash: %v\n", tx.Hash.Hex())
+			fmt.Printf("\t\tEffective Gas Price: %v wei\n", tx.EffectiveGasPrice)
+			fmt.Printf("\t\tGas Used: %d / %d (%.2f%%)\n", tx.GasUsed, tx.GasLimit, 100*tx.GasUsedRatio())
+			fmt.Printf("\t\tFee: %v wei (burnt %v, tip %v)\n", tx.Fee, tx.Burnt, tx.Tip)
+		}
+	}
+
+	fmt.Printf("\nTotal over %d blocks\n", len(report.Blocks))
+	fmt.Printf("\tGas Used: %d / %d (%.2f%%)\n", report.GasUsed, report.GasLimit, 100*report.GasUsedRatio())
+	fmt.Printf("\tTransactions: %d\n", report.Totals.Txs)
+	fmt.Printf("\tFees Paid: %v wei\n", report.Totals.Fee)
+	fmt.Printf("\tBurnt: %v wei\n", report.Totals.Burnt)
+	fmt.Printf("\tTips: %v wei\n", report.Totals.Tip)
+
+	// Senders are listed from the one who paid the most to the one who paid the least.
+
+	senders := make([]common.Address, 0, len(report.BySender))
+	for sender := range report.BySender {
+		senders = append(senders, sender)
+	}
+	sort.Slice(senders, func(i, j int) bool {
+		return report.BySender[senders[i]].Fee.Cmp(report.BySender[senders[j]].Fee) > 0
+	})
+
+	fmt.Printf("\nBy sender\n")
+	for _, sender := range senders {
+		totals := report.BySender[sender]
+		fmt.Printf("\t%v: %d txs, %d gas, fees %v wei (burnt %v, tips %v)\n",
+			sender.Hex(), totals.Txs, totals.GasUsed, totals.Fee, totals.Burnt, totals.Tip)
+	}
+
+	// Fee recipients are listed the same way, by the tips they received.
+
+	recipients := make([]common.Address, 0, len(report.Recipient))
+	for recipient := range report.Recipient {
+		recipients = append(recipients, recipient)
+	}
+	sort.Slice(recipients, func(i, j int) bool {
+		return report.Recipient[recipients[i]].Cmp(report.Recipient[recipients[j]]) > 0
+	})
+
+	fmt.Printf("\nBy fee recipient\n")
+	for _, recipient := range recipients {
+		fmt.Printf("\t%v: tips %v wei\n", recipient.Hex(), report.Recipient[recipient])
+	}
+}
@@ -0,0 +1,82 @@
+package fees
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+
+	"ethereum-go-book/transactions/receipts"
+	"ethereum-go-book/transactions/txinspect"
+
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// Analyzer fetches blocks and receipts to compute their fees.
+type Analyzer struct {
+	client  *ethclient.Client
+	fetcher *receipts.Fetcher
+	chainID *big.Int
+}
+
+// NewAnalyzer returns an analyzer reading from the given RPC connection.
+func NewAnalyzer(ctx context.Context, c *rpc.Client) (*Analyzer, error) {
+	client := ethclient.NewClient(c)
+	chainID, err := client.ChainID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &Analyzer{
+		client:  client,
+		fetcher: receipts.NewFetcher(c, receipts.DefaultConcurrency),
+		chainID: chainID,
+	}, nil
+}
+
+// Block computes the fees of a single block.
+func (a *Analyzer) Block(ctx context.Context, number uint64) (*BlockFees, error) {
+	block, err := a.client.BlockByNumber(ctx, new(big.Int).SetUint64(number))
+	if err != nil {
+		return nil, err
+	}
+	results, err := a.fetcher.BlockReceipts(ctx, block)
+	if err != nil {
+		return nil, err
+	}
+
+	header := block.Header()
+	fees := &BlockFees{
+		Number:       number,
+		FeeRecipient: header.Coinbase,
+		BaseFee:      header.BaseFee,
+		GasUsed:      header.GasUsed,
+		GasLimit:     header.GasLimit,
+		Totals:       NewTotals(),
+	}
+	for i, tx := range block.Transactions() {
+		if results[i].Err != nil {
+			return nil, results[i].Err
+		}
+		from, err := txinspect.Sender(tx, a.chainID)
+		if err != nil {
+			return nil, err
+		}
+		fee := ForTx(header, tx, results[i].Receipt, from)
+		fees.Txs = append(fees.Txs, fee)
+		fees.Totals.Add(fee)
+	}
+	return fees, nil
+}
+
+// Range computes the fees of the blocks in [from, to].
+func (a *Analyzer) Range(ctx context.Context, from, to uint64) (*Report, error) {
+	report := NewReport()
+	for n := from; n <= to; n++ {
+		block, err := a.Block(ctx, n)
+		if err != nil {
+			return nil, fmt.Errorf("block %d: %v", n, err)
+		}
+		report.Add(block)
+	}
+	return report, nil
+}
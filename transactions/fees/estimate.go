@@ -0,0 +1,132 @@
+package fees
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+
+	ethereum "github.com/ethereum/go-ethereum"
+)
+
+// DefaultHistoryBlocks is how many recent blocks fee estimates look at.
+const DefaultHistoryBlocks = 20
+
+// ErrNoBaseFee is returned by Estimate on chains that haven't activated
+// London; use a legacy gas price there instead.
+var ErrNoBaseFee = errors.New("fees: chain has no base fee (pre-London)")
+
+// Strategy trades inclusion speed for cost.
+type Strategy string
+
+// The available strategies.
+const (
+	Slow   Strategy = "slow"
+	Normal Strategy = "normal"
+	Fast   Strategy = "fast"
+)
+
+// strategyParams sets, per strategy, the percentile of the tips paid in
+// recent blocks that is offered, and how much the max fee leaves room for the
+// base fee to rise: the base fee grows by at most 12.5% per full block, so
+// 2x covers six full blocks in a row.
+var strategyParams = map[Strategy]struct {
+	percentile float64
+	headroom   *big.Rat
+}{
+	Slow:   {10, big.NewRat(5, 4)},
+	Normal: {50, big.NewRat(2, 1)},
+	Fast:   {90, big.NewRat(2, 1)},
+}
+
+// ParseStrategy parses "slow", "normal" or "fast".
+func ParseStrategy(s string) (Strategy, error) {
+	if _, ok := strategyParams[Strategy(s)]; !ok {
+		return "", fmt.Errorf("unknown fee strategy %q, want slow, normal or fast", s)
+	}
+	return Strategy(s), nil
+}
+
+// FeeHistoryReader is the part of ethclient.Client needed to estimate fees.
+type FeeHistoryReader interface {
+	FeeHistory(ctx context.Context, blockCount uint64, lastBlock *big.Int, rewardPercentiles []float64) (*ethereum.FeeHistory, error)
+	SuggestGasTipCap(ctx context.Context) (*big.Int, error)
+}
+
+// Estimate is a suggested max fee and priority tip for a dynamic fee
+// transaction, along with the base fee expected in the next block.
+type Estimate struct {
+	Strategy  Strategy
+	BaseFee   *big.Int // of the next block
+	GasTipCap *big.Int // max priority fee per gas
+	GasFeeCap *big.Int // max fee per gas
+}
+
+// Estimator suggests fees from eth_feeHistory.
+type Estimator struct {
+	reader FeeHistoryReader
+	blocks uint64
+}
+
+// NewEstimator returns an estimator looking at the given number of recent
+// blocks, or DefaultHistoryBlocks if 0.
+func NewEstimator(reader FeeHistoryReader, blocks uint64) *Estimator {
+	if blocks == 0 {
+		blocks = DefaultHistoryBlocks
+	}
+	return &Estimator{reader: reader, blocks: blocks}
+}
+
+// Estimate suggests fees for the given strategy. The tip is the median, over
+// the recent non-empty blocks, of the strategy's percentile of the tips paid
+// in each block; when every block was empty the node's eth_maxPriorityFeePerGas
+// is used. The max fee is the next base fee times the strategy's headroom,
+// plus the tip.
+func (e *Estimator) Estimate(ctx context.Context, strategy Strategy) (*Estimate, error) {
+	params, ok := strategyParams[strategy]
+	if !ok {
+		return nil, fmt.Errorf("unknown fee strategy %q", strategy)
+	}
+
+	history, err := e.reader.FeeHistory(ctx, e.blocks, nil, []float64{params.percentile})
+	if err != nil {
+		return nil, err
+	}
+	if len(history.BaseFee) == 0 || history.BaseFee[len(history.BaseFee)-1] == nil ||
+		history.BaseFee[len(history.BaseFee)-1].Sign() == 0 {
+		return nil, ErrNoBaseFee
+	}
+	// BaseFee has one more entry than there are blocks: the base fee of the
+	// block after the newest one.
+	baseFee := history.BaseFee[len(history.BaseFee)-1]
+
+	var tips []*big.Int
+	for i, rewards := range history.Reward {
+		if i < len(history.GasUsedRatio) && history.GasUsedRatio[i] == 0 {
+			continue // empty blocks report a zero tip
+		}
+		if len(rewards) > 0 && rewards[0] != nil {
+			tips = append(tips, rewards[0])
+		}
+	}
+
+	var tip *big.Int
+	if len(tips) > 0 {
+		sort.Slice(tips, func(i, j int) bool { return tips[i].Cmp(tips[j]) < 0 })
+		tip = new(big.Int).Set(tips[len(tips)/2])
+	} else if tip, err = e.reader.SuggestGasTipCap(ctx); err != nil {
+		return nil, err
+	}
+
+	feeCap := new(big.Int).Mul(baseFee, params.headroom.Num())
+	feeCap.Quo(feeCap, params.headroom.Denom())
+	feeCap.Add(feeCap, tip)
+
+	return &Estimate{
+		Strategy:  strategy,
+		BaseFee:   new(big.Int).Set(baseFee),
+		GasTipCap: tip,
+		GasFeeCap: feeCap,
+	}, nil
+}
@@ -0,0 +1,177 @@
+// Package fees works out what transactions actually paid for gas.
+//
+// Before the London fork a transaction paid gasUsed * gasPrice and all of it
+// went to the miner. Since London (EIP-1559) each block has a base fee that
+// is burnt, and the fee recipient only gets the priority tip on top of it:
+// the effective gas price is min(maxFeePerGas, baseFee + maxPriorityFeePerGas)
+// and only effectiveGasPrice - baseFee of it per gas goes to the recipient.
+//
+// All amounts are in wei and kept as big.Int.
+//
+// Going the other way, Estimator suggests the max fee and tip of a new
+// transaction from the tips recently paid, as reported by eth_feeHistory.
+package fees
+
+import (
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// TxFee is what a single transaction paid.
+type TxFee struct {
+	Hash     common.Hash
+	From     common.Address
+	GasLimit uint64
+	GasUsed  uint64
+
+	EffectiveGasPrice *big.Int // wei per gas actually paid
+	Fee               *big.Int // GasUsed * EffectiveGasPrice
+	Burnt             *big.Int // GasUsed * base fee, zero before London
+	Tip               *big.Int // Fee - Burnt, paid to the block's fee recipient
+}
+
+// GasUsedRatio returns gasUsed/gasLimit, how much of its gas limit the
+// transaction needed.
+func (f *TxFee) GasUsedRatio() float64 {
+	if f.GasLimit == 0 {
+		return 0
+	}
+	return float64(f.GasUsed) / float64(f.GasLimit)
+}
+
+// EffectiveGasPrice returns the price per gas tx paid in a block with the
+// given base fee. Pass a nil base fee for blocks before London.
+func EffectiveGasPrice(tx *types.Transaction, baseFee *big.Int) *big.Int {
+	if baseFee == nil {
+		return new(big.Int).Set(tx.GasPrice())
+	}
+	price := new(big.Int).Add(baseFee, tx.GasTipCap())
+	if price.Cmp(tx.GasFeeCap()) > 0 {
+		price.Set(tx.GasFeeCap())
+	}
+	return price
+}
+
+// ForTx computes the fee paid by tx, included in the block with the given
+// header and sent by from. The effective gas price reported in the receipt
+// is used when present, and computed from the transaction otherwise.
+func ForTx(header *types.Header, tx *types.Transaction, receipt *types.Receipt, from common.Address) *TxFee {
+	price := receipt.EffectiveGasPrice
+	if price == nil || price.Sign() == 0 {
+		price = EffectiveGasPrice(tx, header.BaseFee)
+	}
+	gasUsed := new(big.Int).SetUint64(receipt.GasUsed)
+
+	fee := new(big.Int).Mul(gasUsed, price)
+	burnt := new(big.Int)
+	if header.BaseFee != nil {
+		burnt.Mul(gasUsed, header.BaseFee)
+	}
+
+	return &TxFee{
+		Hash:              tx.Hash(),
+		From:              from,
+		GasLimit:          tx.Gas(),
+		GasUsed:           receipt.GasUsed,
+		EffectiveGasPrice: price,
+		Fee:               fee,
+		Burnt:             burnt,
+		Tip:               new(big.Int).Sub(fee, burnt),
+	}
+}
+
+// Totals accumulates fees over many transactions.
+type Totals struct {
+	Txs     int
+	GasUsed uint64
+	Fee     *big.Int
+	Burnt   *big.Int
+	Tip     *big.Int
+}
+
+// NewTotals returns zeroed totals.
+func NewTotals() *Totals {
+	return &Totals{Fee: new(big.Int), Burnt: new(big.Int), Tip: new(big.Int)}
+}
+
+// Add adds a transaction fee to the totals.
+func (t *Totals) Add(fee *TxFee) {
+	t.Txs++
+	t.GasUsed += fee.GasUsed
+	t.Fee.Add(t.Fee, fee.Fee)
+	t.Burnt.Add(t.Burnt, fee.Burnt)
+	t.Tip.Add(t.Tip, fee.Tip)
+}
+
+// BlockFees summarises the fees of a block.
+type BlockFees struct {
+	Number       uint64
+	FeeRecipient common.Address
+	BaseFee      *big.Int // nil before London
+	GasUsed      uint64
+	GasLimit     uint64
+	Txs          []*TxFee
+	Totals       *Totals
+}
+
+// GasUsedRatio returns gasUsed/gasLimit, how full the block is.
+func (b *BlockFees) GasUsedRatio() float64 {
+	if b.GasLimit == 0 {
+		return 0
+	}
+	return float64(b.GasUsed) / float64(b.GasLimit)
+}
+
+// Report summarises the fees of a range of blocks.
+type Report struct {
+	Blocks    []*BlockFees
+	Totals    *Totals
+	BySender  map[common.Address]*Totals
+	GasUsed   uint64
+	GasLimit  uint64
+	Recipient map[common.Address]*big.Int // tips received per fee recipient
+}
+
+// NewReport returns an empty report.
+func NewReport() *Report {
+	return &Report{
+		Totals:    NewTotals(),
+		BySender:  make(map[common.Address]*Totals),
+		Recipient: make(map[common.Address]*big.Int),
+	}
+}
+
+// Add adds the fees of a block to the report.
+func (r *Report) Add(block *BlockFees) {
+	r.Blocks = append(r.Blocks, block)
+	r.GasUsed += block.GasUsed
+	r.GasLimit += block.GasLimit
+
+	for _, fee := range block.Txs {
+		r.Totals.Add(fee)
+
+		sender, ok := r.BySender[fee.From]
+		if !ok {
+			sender = NewTotals()
+			r.BySender[fee.From] = sender
+		}
+		sender.Add(fee)
+	}
+
+	tips, ok := r.Recipient[block.FeeRecipient]
+	if !ok {
+		tips = new(big.Int)
+		r.Recipient[block.FeeRecipient] = tips
+	}
+	tips.Add(tips, block.Totals.Tip)
+}
+
+// GasUsedRatio returns the gas used over the gas limit across all blocks.
+func (r *Report) GasUsedRatio() float64 {
+	if r.GasLimit == 0 {
+		return 0
+	}
+	return float64(r.GasUsed) / float64(r.GasLimit)
+}
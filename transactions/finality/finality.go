@@ -0,0 +1,264 @@
+// Package finality turns a stream of chain heads into a stream of blocks that
+// are safe to act on.
+//
+// A block that was just mined can still be replaced by a reorg. The usual
+// answer is to wait until enough blocks were built on top of it: a block at
+// height B has T-B+1 confirmations when the head is at height T. Since the
+// merge, nodes also report the latest "safe" and "finalized" blocks, which
+// carry a much stronger guarantee than any depth. The Notifier releases a
+// block as soon as either condition holds, and should a released block still
+// be reorged away it sends an explicit retraction for it.
+package finality
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	ethereum "github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// Tags that can be passed to NewNotifier.
+const (
+	TagNone      = ""
+	TagSafe      = "safe"
+	TagFinalized = "finalized"
+)
+
+// Kind tells what an Event means.
+type Kind int
+
+const (
+	// Confirmed means the block reached the required depth or was reported
+	// under the configured tag.
+	Confirmed Kind = iota
+
+	// Retracted means a previously confirmed block is no longer part of the
+	// canonical chain.
+	Retracted
+)
+
+func (k Kind) String() string {
+	switch k {
+	case Confirmed:
+		return "confirmed"
+	case Retracted:
+		return "retracted"
+	default:
+		return fmt.Sprintf("Kind(%d)", int(k))
+	}
+}
+
+// Event is a confirmation or a retraction of a block.
+type Event struct {
+	Kind          Kind
+	Header        *types.Header
+	Confirmations uint64 // depth of the block when the event was sent
+	Reason        string // "depth", or the tag the block was reported under
+}
+
+// HeaderReader is the subset of ethclient.Client used to query tags.
+type HeaderReader interface {
+	HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error)
+}
+
+// entry is a block of the current canonical chain known to the notifier.
+type entry struct {
+	header   *types.Header
+	released bool
+}
+
+// Notifier releases blocks once they are confirmed. It expects heads in the
+// order delivered by heads.Follower: every header's parent was delivered
+// before it, and a header at or below the current height starts a new branch.
+type Notifier struct {
+	reader HeaderReader
+	depth  uint64
+	tag    string
+	retain uint64 // released blocks kept to detect their retraction
+
+	chain []*entry // canonical chain, oldest first, consecutive numbers
+}
+
+// NewNotifier returns a notifier releasing blocks with at least depth
+// confirmations or, when tag is TagSafe or TagFinalized, reported under that
+// tag by the node behind reader, whichever comes first. A zero depth only
+// releases on the tag.
+func NewNotifier(reader HeaderReader, depth uint64, tag string) (*Notifier, error) {
+	switch tag {
+	case TagNone, TagSafe, TagFinalized:
+	default:
+		return nil, fmt.Errorf("unknown block tag %q", tag)
+	}
+	if depth == 0 && tag == TagNone {
+		return nil, fmt.Errorf("need a confirmation depth or a block tag")
+	}
+	return &Notifier{reader: reader, depth: depth, tag: tag, retain: depth + 128}, nil
+}
+
+// Run reads heads until the context is cancelled, the channel is closed or
+// errc delivers an error, and sends the resulting events to out.
+func (n *Notifier) Run(ctx context.Context, heads <-chan *types.Header, errc <-chan error, out chan<- Event) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case err := <-errc:
+			return err
+		case head, ok := <-heads:
+			if !ok {
+				return nil
+			}
+			events, err := n.Process(ctx, head)
+			if err != nil {
+				return err
+			}
+			for _, event := range events {
+				select {
+				case out <- event:
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+		}
+	}
+}
+
+// Process adds a new head and returns the events it causes, retractions
+// first and then confirmations in ascending block order.
+func (n *Notifier) Process(ctx context.Context, head *types.Header) ([]Event, error) {
+	var events []Event
+
+	// A head at or below the current height replaces the blocks from its
+	// height on. Released ones among them must be taken back.
+	if len(n.chain) > 0 {
+		first := n.chain[0].header.Number.Uint64()
+		number := head.Number.Uint64()
+		switch {
+		case number < first:
+			return nil, fmt.Errorf("block %d is below the oldest remembered block %d", number, first)
+		case number <= first+uint64(len(n.chain))-1:
+			replaced := n.chain[number-first:]
+			for i := len(replaced) - 1; i >= 0; i-- {
+				if replaced[i].released {
+					events = append(events, Event{Kind: Retracted, Header: replaced[i].header, Reason: "reorg"})
+				}
+			}
+			n.chain = n.chain[:number-first]
+		case number > first+uint64(len(n.chain)):
+			return nil, fmt.Errorf("block %d does not follow block %d", number, first+uint64(len(n.chain))-1)
+		}
+	}
+	n.chain = append(n.chain, &entry{header: head})
+
+	tip := head.Number.Uint64()
+	if n.depth > 0 {
+		for _, e := range n.chain {
+			if e.released {
+				continue
+			}
+			confirmations := tip - e.header.Number.Uint64() + 1
+			if confirmations < n.depth {
+				break
+			}
+			e.released = true
+			events = append(events, Event{Kind: Confirmed, Header: e.header, Confirmations: confirmations, Reason: "depth"})
+		}
+	}
+
+	if n.tag != TagNone {
+		tagged, err := n.tagged(ctx)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, n.releaseTagged(tagged, tip)...)
+	}
+
+	n.prune(tip)
+	return events, nil
+}
+
+// releaseTagged releases the unreleased blocks up to the tagged one, if the
+// tagged block is on our chain.
+func (n *Notifier) releaseTagged(tagged *types.Header, tip uint64) []Event {
+	if tagged == nil || len(n.chain) == 0 {
+		return nil
+	}
+	first := n.chain[0].header.Number.Uint64()
+	number := tagged.Number.Uint64()
+	if number < first || number > tip || n.chain[number-first].header.Hash() != tagged.Hash() {
+		return nil
+	}
+
+	var events []Event
+	for _, e := range n.chain[:number-first+1] {
+		if e.released {
+			continue
+		}
+		e.released = true
+		events = append(events, Event{
+			Kind:          Confirmed,
+			Header:        e.header,
+			Confirmations: tip - e.header.Number.Uint64() + 1,
+			Reason:        n.tag,
+		})
+	}
+	return events
+}
+
+// tagged returns the header the node reports under the configured tag, or
+// nil if the node does not know the tag (pre-merge chains).
+func (n *Notifier) tagged(ctx context.Context) (*types.Header, error) {
+	number := rpc.SafeBlockNumber
+	if n.tag == TagFinalized {
+		number = rpc.FinalizedBlockNumber
+	}
+	header, err := n.reader.HeaderByNumber(ctx, big.NewInt(number.Int64()))
+	if tagNotAvailable(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return header, nil
+}
+
+// tagNotAvailable reports whether err means the chain has no block under
+// the tag yet. Nodes say so in different ways: -39001 "unknown block",
+// geth's -32000 "finalized block not found" and "safe block not found", or
+// a null result, which ethclient turns into ethereum.NotFound.
+func tagNotAvailable(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, ethereum.NotFound) {
+		return true
+	}
+	var rpcErr rpc.Error
+	if !errors.As(err, &rpcErr) {
+		return false
+	}
+	switch rpcErr.ErrorCode() {
+	case -39001:
+		return true
+	case -32000:
+		return strings.Contains(strings.ToLower(err.Error()), "block not found")
+	}
+	return false
+}
+
+// prune forgets released blocks that are too deep to ever be reorged.
+func (n *Notifier) prune(tip uint64) {
+	drop := 0
+	for _, e := range n.chain {
+		if !e.released || tip-e.header.Number.Uint64() < n.retain {
+			break
+		}
+		drop++
+	}
+	n.chain = n.chain[drop:]
+}
@@ -0,0 +1,156 @@
+package main
+
+/*
+
+  Keeping a Local Copy of the Headers
+
+  Block headers never change once they are buried deep enough, so there is no
+  reason to keep asking the node for them. In this section we sync headers into
+  a small LevelDB store, checking that every header points at its parent by hash,
+  and then answer queries from the store without any network access.
+
+  Sync a range of blocks over HTTP:
+
+  $ go run header_sync.go -db ./headers -from 6339700 -to 6339747
+
+  Ranges don't have to be next to each other. A range away from the stored
+  headers is kept as a separate segment, and segments join up once the
+  blocks between them are synced too.
+
+  Keep following the chain head, over websockets or by polling over HTTP:
+
+  $ go run header_sync.go -db ./headers -rpc wss://mainnet.infura.io/ws -follow
+
+  While following, every new head can also be sent to sinks, with -sink as
+  in the block subscriber.
+
+  Look up a header offline, by number or by hash:
+
+  $ go run header_sync.go -db ./headers -get 6339747
+
+*/
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"ethereum-go-book/transactions/headerdb"
+	"ethereum-go-book/transactions/heads"
+	"ethereum-go-book/transactions/sinks"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+)
+
+func main() {
+	url := flag.String("rpc", "https://mainnet.infura.io", "JSON-RPC endpoint")
+	path := flag.String("db", "./headers", "header store directory")
+	from := flag.Uint64("from", 0, "first block to sync")
+	to := flag.Uint64("to", 0, "last block to sync")
+	follow := flag.Bool("follow", false, "follow new heads after syncing")
+	get := flag.String("get", "", "print the stored header with this number or hash and exit")
+	sinkSpecs := flag.String("sink", "", "comma separated sinks: stdout, file:PATH or a webhook URL")
+	secret := flag.String("webhook-secret", "", "key to sign webhook requests with (HMAC-SHA256)")
+	flag.Parse()
+
+	db, err := headerdb.Open(*path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	// Queries are answered from the store alone, we don't even dial the node.
+
+	if *get != "" {
+		header, err := lookup(db, *get)
+		if err != nil {
+			log.Fatal(err)
+		}
+		printHeader(header)
+		return
+	}
+
+	client, err := ethclient.Dial(*url)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *to >= *from && *to > 0 {
+		if err := db.Sync(context.Background(), client, *from, *to); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// The head follower subscribes over websockets and polls over HTTP, and
+	// reconnects either way, so -follow works with any endpoint.
+
+	if *follow {
+		follower := heads.NewFollower(heads.Config{URL: *url})
+		headers := make(chan *types.Header)
+		sub, err := follower.SubscribeNewHead(context.Background(), headers)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer sub.Unsubscribe()
+
+		sink, err := sinks.Open(*sinkSpecs, *secret)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer sink.Close()
+
+		// Headers pass through on their way to the store, so that the sinks
+		// see them as they arrive.
+
+		stored := make(chan *types.Header)
+		go func() {
+			defer close(stored)
+			for header := range headers {
+				rec := sinks.NewRecord("head", map[string]interface{}{
+					"number":     header.Number.Uint64(),
+					"hash":       header.Hash().Hex(),
+					"parentHash": header.ParentHash.Hex(),
+				})
+				if err := sink.Send(context.Background(), rec); err != nil {
+					log.Printf("sink: %v", err)
+				}
+				stored <- header
+			}
+		}()
+
+		if err := db.Follow(context.Background(), client, stored, sub.Err()); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	head, err := db.Head()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if head != nil {
+		printHeader(head)
+	}
+}
+
+func lookup(db *headerdb.DB, key string) (*types.Header, error) {
+	if strings.HasPrefix(key, "0x") {
+		return db.HeaderByHash(context.Background(), common.HexToHash(key))
+	}
+	number, err := strconv.ParseUint(key, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	return db.HeaderByNumber(context.Background(), new(big.Int).SetUint64(number))
+}
+
+func printHeader(header *types.Header) {
+	fmt.Printf("\tNumber: %v\n", header.Number)
+	fmt.Printf("\tHash: %v\n", header.Hash().Hex())
+	fmt.Printf("\tParent Hash: %v\n", header.ParentHash.Hex())
+	fmt.Printf("\tTime: %v\n", header.Time)
+}
@@ -0,0 +1,361 @@
+// Package headerdb is a small embedded header database.
+//
+// Headers are synced from a node with HeaderByNumber or SubscribeNewHead and
+// stored in LevelDB. A header must link to a header already in the store
+// through its parent hash, unless nothing is stored at the height below it:
+// then it starts a new segment, so ranges far apart can be synced without
+// fetching everything in between. Side-chain headers left behind by a reorg
+// are kept, and the canonical chain is the longest branch. Where segments
+// meet, the headers the node returned by number are canonical.
+//
+// DB implements the HeaderByNumber and HeaderByHash methods of ethclient, so
+// tools that only need old headers can read them from the store instead of
+// asking the node again.
+package headerdb
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+
+	ethereum "github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// Key layout:
+//
+//	"h" + hash        -> RLP encoded header
+//	"n" + num + hash  -> nothing, indexes every header stored at a height
+//	"c" + num         -> hash of the canonical header at a height
+//	"LastHeader"      -> hash of the canonical head
+var (
+	headerPrefix    = []byte("h")
+	numberPrefix    = []byte("n")
+	canonicalPrefix = []byte("c")
+	headKey         = []byte("LastHeader")
+)
+
+var (
+	// ErrUnknownParent is returned when inserting a header whose parent is
+	// not in the store.
+	ErrUnknownParent = errors.New("unknown parent")
+
+	// ErrBadLink is returned when a header's parent is known but the numbers
+	// of the two headers are not consecutive.
+	ErrBadLink = errors.New("parent number mismatch")
+)
+
+// DB is a persistent header store. It is safe for concurrent use.
+type DB struct {
+	db *leveldb.DB
+	mu sync.Mutex // serialises writes
+}
+
+// Open opens or creates a header store in the given directory.
+func Open(path string) (*DB, error) {
+	db, err := leveldb.OpenFile(path, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &DB{db: db}, nil
+}
+
+// Close releases the underlying database.
+func (db *DB) Close() error {
+	return db.db.Close()
+}
+
+// Insert stores a header after checking that it links to its parent. The
+// first header written to an empty store is accepted as the root. Inserting
+// a header that is already stored only checks that it still links to the
+// headers below it. It reports whether the header became the new canonical
+// head.
+func (db *DB) Insert(header *types.Header) (bool, error) {
+	return db.insert(header, false, false)
+}
+
+// InsertCanonical is Insert for a header the node returned by number. Below
+// the head it replaces whatever the canonical index held at its height, so
+// syncing a range again after a reorg corrects it. Heights above it still
+// point at the old branch until they are synced too.
+func (db *DB) InsertCanonical(header *types.Header) (bool, error) {
+	return db.insert(header, false, true)
+}
+
+// InsertSegment stores a header that starts a new segment: its parent is not
+// stored, and neither is anything else at the height below it. The header
+// must come from the node's canonical chain, and is canonical at its height.
+// It becomes the head if it is higher than the current one.
+func (db *DB) InsertSegment(header *types.Header) (bool, error) {
+	return db.insert(header, true, true)
+}
+
+func (db *DB) insert(header *types.Header, segment, canonical bool) (bool, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	head, err := db.head()
+	if err != nil {
+		return false, err
+	}
+	hash := header.Hash()
+	number := header.Number.Uint64()
+	stored, err := db.db.Has(headerKey(hash), nil)
+	if err != nil {
+		return false, err
+	}
+	if stored {
+		return false, db.rejoin(header, head, canonical)
+	}
+
+	switch {
+	case head == nil:
+	case segment:
+		if number > 0 {
+			below, err := db.HeadersByNumber(number - 1)
+			if err != nil {
+				return false, err
+			}
+			if len(below) > 0 {
+				return false, fmt.Errorf("header %v (%v) starts a segment, but headers are stored below it", header.Number, hash.Hex())
+			}
+		}
+	default:
+		parent, err := db.header(header.ParentHash)
+		if err != nil {
+			return false, err
+		}
+		if parent == nil {
+			return false, fmt.Errorf("header %v (%v): %w", header.Number, hash.Hex(), ErrUnknownParent)
+		}
+		if new(big.Int).Add(parent.Number, common.Big1).Cmp(header.Number) != 0 {
+			return false, fmt.Errorf("header %v (%v) on parent %v: %w", header.Number, hash.Hex(), parent.Number, ErrBadLink)
+		}
+	}
+
+	enc, err := rlp.EncodeToBytes(header)
+	if err != nil {
+		return false, err
+	}
+
+	batch := new(leveldb.Batch)
+	batch.Put(headerKey(hash), enc)
+	batch.Put(numberKey(number, hash), nil)
+
+	// The longest branch is canonical. On a tie the current head stays, so
+	// that a competing block at the same height does not flip the chain
+	// back and forth.
+	reorg := head == nil || header.Number.Cmp(head.Number) > 0
+	if reorg {
+		if err := db.setCanonical(batch, header, head); err != nil {
+			return false, err
+		}
+	} else {
+		// Below the head, a header the node returned by number is canonical.
+		// Otherwise a header extending the canonical chain fills an empty
+		// height of the canonical index, which is how an earlier segment
+		// grows up to the one above it.
+		current, err := db.canonicalHash(number)
+		if err != nil {
+			return false, err
+		}
+		extends := false
+		if !canonical && current == (common.Hash{}) && number > 0 {
+			parent, err := db.canonicalHash(number - 1)
+			if err != nil {
+				return false, err
+			}
+			extends = parent == header.ParentHash
+		}
+		if canonical && header.Number.Cmp(head.Number) < 0 || extends {
+			batch.Put(canonicalKey(number), hash.Bytes())
+		}
+	}
+	return reorg, db.db.Write(batch, nil)
+}
+
+// rejoin checks a header that is already stored. Where a segment synced
+// from below reaches the first header of the one above, that header was
+// stored without its parent, so the link is only checked now: it fails with
+// ErrUnknownParent if headers are stored below it but none is its parent.
+// A canonical header below the head is also made canonical at its height.
+func (db *DB) rejoin(header, head *types.Header, canonical bool) error {
+	hash, number := header.Hash(), header.Number.Uint64()
+	if number > 0 {
+		parent, err := db.header(header.ParentHash)
+		if err != nil {
+			return err
+		}
+		if parent == nil {
+			below, err := db.HeadersByNumber(number - 1)
+			if err != nil {
+				return err
+			}
+			if len(below) > 0 {
+				return fmt.Errorf("header %v (%v) doesn't link to the headers stored below it: %w", header.Number, hash.Hex(), ErrUnknownParent)
+			}
+		}
+	}
+	if !canonical || header.Number.Cmp(head.Number) >= 0 {
+		return nil
+	}
+	current, err := db.canonicalHash(number)
+	if err != nil || current == hash {
+		return err
+	}
+	return db.db.Put(canonicalKey(number), hash.Bytes(), nil)
+}
+
+// setCanonical points the canonical index at the branch ending in header,
+// walking back until it meets the current canonical chain.
+func (db *DB) setCanonical(batch *leveldb.Batch, header, oldHead *types.Header) error {
+	batch.Put(headKey, header.Hash().Bytes())
+
+	if oldHead != nil {
+		for n := oldHead.Number.Uint64(); n > header.Number.Uint64(); n-- {
+			batch.Delete(canonicalKey(n))
+		}
+	}
+
+	hash, number := header.Hash(), header.Number.Uint64()
+	for {
+		current, err := db.canonicalHash(number)
+		if err != nil {
+			return err
+		}
+		if current == hash {
+			return nil
+		}
+		batch.Put(canonicalKey(number), hash.Bytes())
+		if number == 0 {
+			return nil
+		}
+
+		parent, err := db.header(header.ParentHash)
+		if err != nil {
+			return err
+		}
+		if parent == nil {
+			// Reached the first header of a segment.
+			return nil
+		}
+		header, hash, number = parent, parent.Hash(), number-1
+	}
+}
+
+// Head returns the canonical head, or nil if the store is empty.
+func (db *DB) Head() (*types.Header, error) {
+	return db.head()
+}
+
+// HeaderByHash returns the header with the given hash, canonical or not. It
+// returns ethereum.NotFound if the header is not stored.
+func (db *DB) HeaderByHash(ctx context.Context, hash common.Hash) (*types.Header, error) {
+	header, err := db.header(hash)
+	if err == nil && header == nil {
+		err = ethereum.NotFound
+	}
+	return header, err
+}
+
+// HeaderByNumber returns the canonical header at the given height, or the
+// canonical head if number is nil. It returns ethereum.NotFound if there is
+// no such header in the store.
+func (db *DB) HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error) {
+	var (
+		header *types.Header
+		err    error
+	)
+	if number == nil {
+		header, err = db.head()
+	} else {
+		var hash common.Hash
+		if hash, err = db.canonicalHash(number.Uint64()); err == nil && hash != (common.Hash{}) {
+			header, err = db.header(hash)
+		}
+	}
+	if err == nil && header == nil {
+		err = ethereum.NotFound
+	}
+	return header, err
+}
+
+// HeadersByNumber returns every stored header at the given height, including
+// side-chain headers.
+func (db *DB) HeadersByNumber(number uint64) ([]*types.Header, error) {
+	it := db.db.NewIterator(util.BytesPrefix(numberKey(number, common.Hash{})[:len(numberPrefix)+8]), nil)
+	defer it.Release()
+
+	var headers []*types.Header
+	for it.Next() {
+		hash := common.BytesToHash(it.Key()[len(numberPrefix)+8:])
+		header, err := db.header(hash)
+		if err != nil {
+			return nil, err
+		}
+		if header != nil {
+			headers = append(headers, header)
+		}
+	}
+	return headers, it.Error()
+}
+
+func (db *DB) head() (*types.Header, error) {
+	hash, err := db.db.Get(headKey, nil)
+	if err == leveldb.ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return db.header(common.BytesToHash(hash))
+}
+
+func (db *DB) header(hash common.Hash) (*types.Header, error) {
+	enc, err := db.db.Get(headerKey(hash), nil)
+	if err == leveldb.ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	header := new(types.Header)
+	if err := rlp.DecodeBytes(enc, header); err != nil {
+		return nil, fmt.Errorf("corrupt header %v: %v", hash.Hex(), err)
+	}
+	return header, nil
+}
+
+func (db *DB) canonicalHash(number uint64) (common.Hash, error) {
+	hash, err := db.db.Get(canonicalKey(number), nil)
+	if err == leveldb.ErrNotFound {
+		return common.Hash{}, nil
+	}
+	return common.BytesToHash(hash), err
+}
+
+func encodeNumber(number uint64) []byte {
+	enc := make([]byte, 8)
+	binary.BigEndian.PutUint64(enc, number)
+	return enc
+}
+
+func headerKey(hash common.Hash) []byte {
+	return append(append([]byte{}, headerPrefix...), hash.Bytes()...)
+}
+
+func numberKey(number uint64, hash common.Hash) []byte {
+	key := append(append([]byte{}, numberPrefix...), encodeNumber(number)...)
+	return append(key, hash.Bytes()...)
+}
+
+func canonicalKey(number uint64) []byte {
+	return append(append([]byte{}, canonicalPrefix...), encodeNumber(number)...)
+}
@@ -0,0 +1,128 @@
+package headerdb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// MaxReorgDepth bounds how far back Follow walks parent hashes looking for a
+// header that is already stored before giving up.
+const MaxReorgDepth = 128
+
+// ChainReader is the subset of ethclient.Client used to sync headers.
+type ChainReader interface {
+	HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error)
+	HeaderByHash(ctx context.Context, hash common.Hash) (*types.Header, error)
+}
+
+// Sync fetches the headers in [from, to] with HeaderByNumber and inserts them
+// in order. Headers that are already stored are skipped. If the node has
+// reorged since the store was last written, the new branch is fetched back
+// to the last stored ancestor. A range that doesn't touch the stored headers
+// is stored as a separate segment.
+func (db *DB) Sync(ctx context.Context, r ChainReader, from, to uint64) error {
+	for n := from; n <= to; n++ {
+		header, err := r.HeaderByNumber(ctx, new(big.Int).SetUint64(n))
+		if err != nil {
+			return fmt.Errorf("header %d: %v", n, err)
+		}
+		if err := db.insertChain(ctx, r, header, true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Follow inserts every header received on headers, typically the channel
+// given to SubscribeNewHead, until the context is cancelled, the channel is
+// closed or errc delivers an error. Missed ancestors of a received header
+// are fetched by hash back to the stored chain; after a gap longer than
+// MaxReorgDepth the new headers start a new segment instead, and Sync can
+// fill the gap later.
+func (db *DB) Follow(ctx context.Context, r ChainReader, headers <-chan *types.Header, errc <-chan error) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case err := <-errc:
+			return err
+		case header, ok := <-headers:
+			if !ok {
+				return nil
+			}
+			if err := db.insertChain(ctx, r, header, false); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// insertChain inserts header, first fetching and inserting any of its
+// ancestors that are missing from the store. Ancestors are fetched while
+// headers are stored at their height, which means the node reorged since,
+// and to fill a gap of up to MaxReorgDepth blocks above the head. Otherwise
+// the oldest header fetched starts a new segment. If header was returned by
+// number, it and its ancestors are canonical.
+func (db *DB) insertChain(ctx context.Context, r ChainReader, header *types.Header, canonical bool) error {
+	insert := db.Insert
+	if canonical {
+		insert = db.InsertCanonical
+	}
+	head, err := db.Head()
+	if err != nil {
+		return err
+	}
+	var missing []*types.Header
+	for {
+		_, insertErr := insert(header)
+		if insertErr == nil {
+			break
+		}
+		if !errors.Is(insertErr, ErrUnknownParent) {
+			return insertErr
+		}
+		segment, err := db.startsSegment(header, head)
+		if err != nil {
+			return err
+		}
+		if segment {
+			if _, err := db.InsertSegment(header); err != nil {
+				return err
+			}
+			break
+		}
+		if len(missing) >= MaxReorgDepth {
+			return fmt.Errorf("no stored ancestor within %d blocks of %v: %w", MaxReorgDepth, header.Number, insertErr)
+		}
+		missing = append(missing, header)
+		if header, err = r.HeaderByHash(ctx, header.ParentHash); err != nil {
+			return err
+		}
+	}
+	for i := len(missing) - 1; i >= 0; i-- {
+		if _, err := insert(missing[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// startsSegment reports whether header, whose parent is not stored, should
+// start a new segment rather than have its ancestors fetched.
+func (db *DB) startsSegment(header, head *types.Header) (bool, error) {
+	number := header.Number.Uint64()
+	if number == 0 {
+		return true, nil
+	}
+	below, err := db.HeadersByNumber(number - 1)
+	if err != nil || len(below) > 0 {
+		return false, err
+	}
+	nearHead := head != nil && header.Number.Cmp(head.Number) > 0 && number-head.Number.Uint64() <= MaxReorgDepth
+	return !nearHead, nil
+}
@@ -0,0 +1,388 @@
+// Package heads follows the head of the chain without ever skipping a block.
+//
+// A plain SubscribeNewHead subscription fails for good the first time the
+// websocket drops, and the node does not replay the heads mined while we
+// were away. Even a healthy subscription may skip heights, since after a
+// reorg the node only announces the new head and not every block of the new
+// branch. The Follower resubscribes with exponential backoff and, before
+// delivering a header, fetches any of its ancestors that were not delivered
+// yet, so that every delivered header's parent was delivered before it.
+//
+// Subscriptions need a websocket or IPC connection. Given an HTTP endpoint,
+// or a node that does not support notifications, the Follower polls
+// HeaderByNumber instead, and subscribers see the same channel either way.
+package heads
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	ethereum "github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/event"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+var (
+	// ErrReorgTooDeep is returned when the chain reorganised further back
+	// than the follower remembers, so it cannot tell where the new branch
+	// starts.
+	ErrReorgTooDeep = errors.New("reorg deeper than the remembered window")
+
+	// ErrGapTooLarge is returned when more blocks than Config.MaxBackfill
+	// were missed.
+	ErrGapTooLarge = errors.New("too many missed blocks to backfill")
+)
+
+// Config configures a Follower. Zero values select the defaults.
+type Config struct {
+	URL         string        // endpoint to subscribe to, or to poll if it is HTTP
+	BackfillURL string        // endpoint to fetch missed headers from, see below
+	MinBackoff  time.Duration // delay before the first reconnection attempt, default 1s
+	MaxBackoff  time.Duration // upper bound on the delay between attempts, default 1m
+	ReorgWindow int           // number of delivered blocks remembered for reorgs, default 128
+	MaxBackfill int           // most blocks fetched to fill a gap, default 10000
+
+	// MinPollInterval and MaxPollInterval bound the delay between two
+	// polls of an endpoint that cannot push new heads, default 1s and 30s.
+	MinPollInterval time.Duration
+	MaxPollInterval time.Duration
+
+	// BackfillURL should be HTTP, so that fetching missed headers doesn't
+	// depend on the connection whose drop made them missed. It defaults to
+	// URL all the same, since a follower given only a websocket endpoint
+	// should still work: the backfill connection is dropped whenever the
+	// stream fails and dialled again on the next attempt, so it never
+	// outlives the subscription it serves.
+
+	// OnReconnect, if set, is called before every reconnection attempt with
+	// the number of consecutive failures and the error that caused it.
+	OnReconnect func(attempt int, err error)
+
+	// HTTPClient, if set, is used for HTTP endpoints instead of the default
+	// client, for example to instrument the requests.
+	HTTPClient *http.Client
+}
+
+func (cfg *Config) setDefaults() {
+	if cfg.BackfillURL == "" {
+		cfg.BackfillURL = cfg.URL
+	}
+	if cfg.MinBackoff <= 0 {
+		cfg.MinBackoff = time.Second
+	}
+	if cfg.MaxBackoff < cfg.MinBackoff {
+		cfg.MaxBackoff = time.Minute
+	}
+	if cfg.ReorgWindow <= 0 {
+		cfg.ReorgWindow = 128
+	}
+	if cfg.MaxBackfill <= 0 {
+		cfg.MaxBackfill = 10000
+	}
+	if cfg.MinPollInterval <= 0 {
+		cfg.MinPollInterval = time.Second
+	}
+	if cfg.MaxPollInterval < cfg.MinPollInterval {
+		cfg.MaxPollInterval = 30 * time.Second
+	}
+}
+
+// HeaderReader is the subset of ethclient.Client used to backfill headers.
+type HeaderReader interface {
+	HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error)
+	HeaderByHash(ctx context.Context, hash common.Hash) (*types.Header, error)
+}
+
+// Follower delivers the canonical chain of headers in order. It remembers
+// the last header it delivered across reconnections, so only one
+// subscription should be active on a Follower at a time.
+type Follower struct {
+	cfg Config
+
+	backfillMu sync.Mutex
+	backfill   *ethclient.Client // nil until dialled, and after a failure
+
+	mu     sync.Mutex
+	last   *types.Header
+	recent map[uint64]common.Hash // delivered hashes of the last ReorgWindow blocks
+}
+
+// NewFollower returns a follower for the given configuration.
+func NewFollower(cfg Config) *Follower {
+	cfg.setDefaults()
+	return &Follower{cfg: cfg, recent: make(map[uint64]common.Hash)}
+}
+
+// LastDelivered returns the last header sent to a subscriber, or nil.
+func (f *Follower) LastDelivered() *types.Header {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.last
+}
+
+// SubscribeNewHead has the same signature and channel semantics as the
+// ethclient method, but the subscription survives connection failures and
+// never skips a block. Its error channel only fires for failures the
+// follower cannot recover from, such as ErrReorgTooDeep.
+func (f *Follower) SubscribeNewHead(ctx context.Context, ch chan<- *types.Header) (ethereum.Subscription, error) {
+	if _, err := f.backfillClient(ctx); err != nil {
+		return nil, err
+	}
+	return event.NewSubscription(func(quit <-chan struct{}) error {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		go func() {
+			select {
+			case <-quit:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+		return f.run(ctx, ch)
+	}), nil
+}
+
+// run keeps a subscription alive until the context is cancelled or an
+// unrecoverable error occurs.
+func (f *Follower) run(ctx context.Context, ch chan<- *types.Header) error {
+	backoff := f.cfg.MinBackoff
+	for attempt := 1; ; attempt++ {
+		delivered, err := f.stream(ctx, ch)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if errors.Is(err, ErrReorgTooDeep) || errors.Is(err, ErrGapTooLarge) {
+			return err
+		}
+		if delivered {
+			// The connection worked for a while, start backing off afresh.
+			attempt, backoff = 1, f.cfg.MinBackoff
+		}
+		if f.cfg.OnReconnect != nil {
+			f.cfg.OnReconnect(attempt, err)
+		}
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return nil
+		}
+		if backoff *= 2; backoff > f.cfg.MaxBackoff {
+			backoff = f.cfg.MaxBackoff
+		}
+	}
+}
+
+// stream subscribes once, or polls if the endpoint cannot push new heads,
+// and delivers headers until the connection fails. It reports whether any
+// header was delivered.
+func (f *Follower) stream(ctx context.Context, ch chan<- *types.Header) (bool, error) {
+	if isHTTP(f.cfg.URL) {
+		return f.poll(ctx, ch)
+	}
+
+	client, err := ethclient.DialContext(ctx, f.cfg.URL)
+	if err != nil {
+		return false, err
+	}
+	defer client.Close()
+
+	headers := make(chan *types.Header, 16)
+	sub, err := client.SubscribeNewHead(ctx, headers)
+	if errors.Is(err, rpc.ErrNotificationsUnsupported) {
+		return f.poll(ctx, ch)
+	}
+	if err != nil {
+		return false, err
+	}
+	defer sub.Unsubscribe()
+
+	return f.consume(ctx, headers, sub.Err(), ch)
+}
+
+// consume delivers the headers received from a source until it fails.
+func (f *Follower) consume(ctx context.Context, headers <-chan *types.Header, errc <-chan error, ch chan<- *types.Header) (delivered bool, err error) {
+	reader, err := f.backfillClient(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		if err != nil {
+			f.dropBackfill(reader)
+		}
+	}()
+
+	// After a reconnection, catch up with the blocks mined while we were
+	// away right now rather than when the next block arrives.
+	if f.LastDelivered() != nil {
+		head, err := reader.HeaderByNumber(ctx, nil)
+		if err != nil {
+			return false, err
+		}
+		n, err := f.deliver(ctx, reader, head, ch)
+		if err != nil {
+			return false, err
+		}
+		delivered = n > 0
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return delivered, ctx.Err()
+		case err := <-errc:
+			if err == nil {
+				err = errors.New("subscription closed")
+			}
+			return delivered, err
+		case head := <-headers:
+			n, err := f.deliver(ctx, reader, head, ch)
+			if err != nil {
+				return delivered, err
+			}
+			delivered = delivered || n > 0
+		}
+	}
+}
+
+// deliver sends head to ch, preceded by every ancestor of it that was not
+// delivered yet. It returns the number of headers sent.
+func (f *Follower) deliver(ctx context.Context, reader HeaderReader, head *types.Header, ch chan<- *types.Header) (int, error) {
+	chain, err := f.connect(ctx, reader, head)
+	if err != nil {
+		return 0, err
+	}
+	for i, header := range chain {
+		select {
+		case ch <- header:
+			f.record(header)
+		case <-ctx.Done():
+			return i, ctx.Err()
+		}
+	}
+	return len(chain), nil
+}
+
+// connect returns the headers to deliver, oldest first, to extend the
+// delivered chain up to head.
+func (f *Follower) connect(ctx context.Context, reader HeaderReader, head *types.Header) ([]*types.Header, error) {
+	// Work on a copy of the delivered state, so that the lock is not held
+	// while fetching headers.
+	f.mu.Lock()
+	last := f.last
+	recent := make(map[uint64]common.Hash, len(f.recent))
+	for n, hash := range f.recent {
+		recent[n] = hash
+	}
+	f.mu.Unlock()
+
+	if last == nil {
+		return []*types.Header{head}, nil
+	}
+	if head.Hash() == last.Hash() {
+		return nil, nil
+	}
+
+	oldest := uint64(0)
+	if n := last.Number.Uint64(); n >= uint64(f.cfg.ReorgWindow) {
+		oldest = n - uint64(f.cfg.ReorgWindow) + 1
+	}
+
+	// Walk back from head by parent hash until reaching a delivered block.
+	// Numbers above the last delivered block are a gap, numbers at or below
+	// it with a different hash are a reorg.
+	chain := []*types.Header{head}
+	for cur := head; ; {
+		if cur.Number.Sign() == 0 {
+			return nil, fmt.Errorf("%w: reached genesis", ErrReorgTooDeep)
+		}
+		number := cur.Number.Uint64() - 1
+		if hash, ok := recent[number]; ok && hash == cur.ParentHash {
+			break
+		}
+		if number < oldest {
+			return nil, fmt.Errorf("%w: no delivered ancestor of block %v", ErrReorgTooDeep, head.Number)
+		}
+		if len(chain) > f.cfg.MaxBackfill {
+			return nil, fmt.Errorf("%w: %d blocks behind block %v", ErrGapTooLarge, len(chain), head.Number)
+		}
+		parent, err := reader.HeaderByHash(ctx, cur.ParentHash)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, parent)
+		cur = parent
+	}
+
+	for i, j := 0, len(chain)-1; i < j; i, j = i+1, j-1 {
+		chain[i], chain[j] = chain[j], chain[i]
+	}
+	return chain, nil
+}
+
+// record marks header as delivered, forgetting delivered blocks above it
+// (replaced by a reorg) and blocks that fell out of the window.
+func (f *Follower) record(header *types.Header) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	number := header.Number.Uint64()
+	if f.last != nil {
+		for n := f.last.Number.Uint64(); n > number; n-- {
+			delete(f.recent, n)
+		}
+	}
+	f.recent[number] = header.Hash()
+	if number >= uint64(f.cfg.ReorgWindow) {
+		delete(f.recent, number-uint64(f.cfg.ReorgWindow))
+	}
+	f.last = header
+}
+
+// backfillClient returns the client used to fetch missed headers, dialing
+// it if there is none. A failed dial is tried again on the next call.
+func (f *Follower) backfillClient(ctx context.Context) (*ethclient.Client, error) {
+	f.backfillMu.Lock()
+	defer f.backfillMu.Unlock()
+
+	if f.backfill == nil {
+		client, err := f.dial(ctx, f.cfg.BackfillURL)
+		if err != nil {
+			return nil, err
+		}
+		f.backfill = client
+	}
+	return f.backfill, nil
+}
+
+// dropBackfill closes client after a failure, if it is still the backfill
+// client, so that the next backfillClient call dials a fresh one.
+func (f *Follower) dropBackfill(client *ethclient.Client) {
+	f.backfillMu.Lock()
+	defer f.backfillMu.Unlock()
+
+	if f.backfill == client {
+		f.backfill.Close()
+		f.backfill = nil
+	}
+}
+
+// dial connects to an endpoint, through Config.HTTPClient if it is HTTP.
+func (f *Follower) dial(ctx context.Context, endpoint string) (*ethclient.Client, error) {
+	if f.cfg.HTTPClient == nil || !isHTTP(endpoint) {
+		return ethclient.DialContext(ctx, endpoint)
+	}
+	c, err := rpc.DialHTTPWithClient(endpoint, f.cfg.HTTPClient)
+	if err != nil {
+		return nil, err
+	}
+	return ethclient.NewClient(c), nil
+}
@@ -0,0 +1,81 @@
+package heads
+
+import (
+	"context"
+	"net/url"
+	"time"
+
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// poll stands in for a subscription on endpoints that cannot push new heads.
+// It feeds the same consume loop as a subscription, so backfilling and reorg
+// handling work the same way.
+func (f *Follower) poll(ctx context.Context, ch chan<- *types.Header) (bool, error) {
+	client, err := f.dial(ctx, f.cfg.URL)
+	if err != nil {
+		return false, err
+	}
+	defer client.Close()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	headers := make(chan *types.Header)
+	errc := make(chan error, 1)
+	go f.pollHeads(ctx, client, headers, errc)
+
+	return f.consume(ctx, headers, errc, ch)
+}
+
+// pollHeads sends the latest header to headers every time it changes. The
+// interval adapts to the chain: after a new head it is set to half the block
+// time observed between the last two heads, and every poll that finds no
+// new head makes it 50% longer, within the configured bounds.
+func (f *Follower) pollHeads(ctx context.Context, reader HeaderReader, headers chan<- *types.Header, errc chan<- error) {
+	interval := f.cfg.MinPollInterval
+
+	var last *types.Header
+	for {
+		head, err := reader.HeaderByNumber(ctx, nil)
+		if err != nil {
+			errc <- err
+			return
+		}
+
+		if last == nil || head.Hash() != last.Hash() {
+			if last != nil && head.Number.Cmp(last.Number) > 0 && head.Time > last.Time {
+				blocks := head.Number.Uint64() - last.Number.Uint64()
+				interval = time.Duration(head.Time-last.Time) * time.Second / time.Duration(blocks) / 2
+			}
+			select {
+			case headers <- head:
+			case <-ctx.Done():
+				return
+			}
+			last = head
+		} else {
+			interval = interval * 3 / 2
+		}
+
+		if interval < f.cfg.MinPollInterval {
+			interval = f.cfg.MinPollInterval
+		}
+		if interval > f.cfg.MaxPollInterval {
+			interval = f.cfg.MaxPollInterval
+		}
+
+		select {
+		case <-time.After(interval):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// isHTTP reports whether the endpoint is plain HTTP, which cannot carry
+// subscriptions.
+func isHTTP(endpoint string) bool {
+	u, err := url.Parse(endpoint)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https")
+}
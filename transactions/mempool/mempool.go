@@ -0,0 +1,216 @@
+// Package mempool watches pending transactions as they reach a node.
+//
+// The newPendingTransactions subscription only carries transaction hashes,
+// so every hash is looked up with TransactionByHash before it can be
+// filtered. A transaction may already be mined or dropped by the time it is
+// looked up; such hashes are skipped silently.
+package mempool
+
+import (
+	"context"
+	"math/big"
+	"sync"
+	"time"
+
+	"ethereum-go-book/transactions/calldata"
+	"ethereum-go-book/transactions/txinspect"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// DefaultWorkers is the number of concurrent TransactionByHash lookups.
+const DefaultWorkers = 8
+
+// Filter selects pending transactions. Empty fields match everything; a
+// transaction must match every non-empty field.
+type Filter struct {
+	To          []common.Address    // recipient is one of these
+	From        []common.Address    // sender is one of these
+	Selectors   []calldata.Selector // input starts with one of these selectors
+	MinValue    *big.Int            // at least this much wei is sent
+	MinGasPrice *big.Int            // gas price, or max fee per gas, is at least this
+}
+
+// Match reports whether tx, sent by from, passes the filter.
+func (f *Filter) Match(tx *types.Transaction, from common.Address) bool {
+	if len(f.To) > 0 && (tx.To() == nil || !contains(f.To, *tx.To())) {
+		return false
+	}
+	if len(f.From) > 0 && !contains(f.From, from) {
+		return false
+	}
+	if len(f.Selectors) > 0 {
+		data := tx.Data()
+		if len(data) < 4 {
+			return false
+		}
+		var sel calldata.Selector
+		copy(sel[:], data[:4])
+		found := false
+		for _, s := range f.Selectors {
+			if s == sel {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if f.MinValue != nil && tx.Value().Cmp(f.MinValue) < 0 {
+		return false
+	}
+	// For dynamic-fee transactions GasPrice returns the max fee per gas.
+	if f.MinGasPrice != nil && tx.GasPrice().Cmp(f.MinGasPrice) < 0 {
+		return false
+	}
+	return true
+}
+
+func contains(list []common.Address, addr common.Address) bool {
+	for _, a := range list {
+		if a == addr {
+			return true
+		}
+	}
+	return false
+}
+
+// Match is a pending transaction that passed the filter, ready to be
+// written out as JSON.
+type Match struct {
+	SeenAt    time.Time       `json:"seenAt"`
+	Hash      common.Hash     `json:"hash"`
+	Type      uint8           `json:"type"`
+	From      common.Address  `json:"from"`
+	To        *common.Address `json:"to"`
+	Nonce     uint64          `json:"nonce"`
+	Value     *big.Int        `json:"value"`
+	Gas       uint64          `json:"gas"`
+	GasPrice  *big.Int        `json:"gasPrice,omitempty"`
+	GasFeeCap *big.Int        `json:"maxFeePerGas,omitempty"`
+	GasTipCap *big.Int        `json:"maxPriorityFeePerGas,omitempty"`
+	Selector  string          `json:"selector,omitempty"`
+	Call      string          `json:"call,omitempty"` // decoded input, if the selector is known
+}
+
+// Watcher subscribes to pending transactions and filters them.
+type Watcher struct {
+	rpc      *rpc.Client
+	client   *ethclient.Client
+	chainID  *big.Int
+	filter   *Filter
+	registry *calldata.Registry
+	workers  int
+}
+
+// NewWatcher returns a watcher on the given connection, which must support
+// subscriptions. Input is decoded with registry, which may be nil.
+func NewWatcher(ctx context.Context, c *rpc.Client, filter *Filter, registry *calldata.Registry) (*Watcher, error) {
+	client := ethclient.NewClient(c)
+	chainID, err := client.ChainID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if filter == nil {
+		filter = new(Filter)
+	}
+	return &Watcher{
+		rpc:      c,
+		client:   client,
+		chainID:  chainID,
+		filter:   filter,
+		registry: registry,
+		workers:  DefaultWorkers,
+	}, nil
+}
+
+// Watch sends every matching pending transaction to out until the context is
+// cancelled or the subscription fails.
+func (w *Watcher) Watch(ctx context.Context, out chan<- *Match) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	hashes := make(chan common.Hash, 1024)
+	sub, err := w.rpc.EthSubscribe(ctx, hashes, "newPendingTransactions")
+	if err != nil {
+		return err
+	}
+	defer sub.Unsubscribe()
+
+	// Lookups are slower than hashes arrive on a busy node, so a few of
+	// them run at once.
+	var wg sync.WaitGroup
+	for i := 0; i < w.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				var hash common.Hash
+				select {
+				case hash = <-hashes:
+				case <-ctx.Done():
+					return
+				}
+				if match := w.lookup(ctx, hash); match != nil {
+					select {
+					case out <- match:
+					case <-ctx.Done():
+						return
+					}
+				}
+			}
+		}()
+	}
+
+	select {
+	case err = <-sub.Err():
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	cancel()
+	wg.Wait()
+	return err
+}
+
+// lookup fetches a pending transaction and returns it if it matches.
+func (w *Watcher) lookup(ctx context.Context, hash common.Hash) *Match {
+	tx, _, err := w.client.TransactionByHash(ctx, hash)
+	if err != nil {
+		return nil
+	}
+	from, err := txinspect.Sender(tx, w.chainID)
+	if err != nil || !w.filter.Match(tx, from) {
+		return nil
+	}
+
+	match := &Match{
+		SeenAt: time.Now().UTC(),
+		Hash:   hash,
+		Type:   tx.Type(),
+		From:   from,
+		To:     tx.To(),
+		Nonce:  tx.Nonce(),
+		Value:  tx.Value(),
+		Gas:    tx.Gas(),
+	}
+	if tx.Type() == types.DynamicFeeTxType {
+		match.GasFeeCap, match.GasTipCap = tx.GasFeeCap(), tx.GasTipCap()
+	} else {
+		match.GasPrice = tx.GasPrice()
+	}
+	if data := tx.Data(); len(data) >= 4 {
+		var sel calldata.Selector
+		copy(sel[:], data[:4])
+		match.Selector = sel.Hex()
+		if w.registry != nil {
+			if call, err := w.registry.Decode(data); err == nil && call.Known() {
+				match.Call = call.String()
+			}
+		}
+	}
+	return match
+}
@@ -0,0 +1,65 @@
+package main
+
+/*
+
+  Nonce Status
+
+  The transfer and contract tools take their nonces from a shared nonce
+  manager. If one of their transactions never reaches the network, say the
+  node dropped it from its pool, every transaction sent after it with a
+  higher nonce is stuck. This tool compares the manager's records with the
+  node and reports such gaps. With -rewind the manager hands out the missing
+  nonces again, so the next transactions fill the gap.
+
+  A nonce handed out less than -grace ago is only reported as reserved and
+  never reissued: its transaction may still be on its way, for instance
+  waiting to be signed offline.
+
+  $ go run nonce_status.go -account 0x96216849c49358B10257cb55b28eA603c874b05E
+  $ go run nonce_status.go -account 0x96216849c49358B10257cb55b28eA603c874b05E -rewind
+
+*/
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+
+	"ethereum-go-book/transactions/nonces"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethclient"
+)
+
+func main() {
+	url := flag.String("rpc", "https://rinkeby.infura.io", "JSON-RPC endpoint")
+	path := flag.String("db", nonces.DefaultPath(), "nonce store")
+	account := flag.String("account", "", "account to check")
+	rewind := flag.Bool("rewind", false, "reissue the missing nonces when there is a gap")
+	grace := flag.Duration("grace", nonces.DefaultGracePeriod, "how long a handed out nonce may stay unknown to the node")
+	flag.Parse()
+
+	if !common.IsHexAddress(*account) {
+		log.Fatalf("invalid account %q", *account)
+	}
+
+	client, err := ethclient.Dial(*url)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	manager := nonces.New(*path, client)
+	manager.GracePeriod = *grace
+	status, err := manager.Resync(context.Background(), common.HexToAddress(*account), *rewind)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Println(status)
+	if status.HasGap() {
+		fmt.Printf("stuck nonces: %v\n", status.Gaps)
+		if *rewind {
+			fmt.Println("rewound, the next transactions get these nonces again")
+		}
+	}
+}
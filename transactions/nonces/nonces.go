@@ -0,0 +1,378 @@
+// Package nonces hands out transaction nonces for local accounts.
+//
+// Asking the node with PendingNonceAt right before signing works for one
+// sender at a time. Two programs sending from the same key at once both get
+// the same nonce and one of the transactions replaces, or is rejected in
+// favour of, the other. A Manager keeps the next nonce of every account in a
+// LevelDB store shared by all the tools on the machine and hands nonces out
+// under a lock, so concurrent senders, in one process or several, always
+// get distinct nonces, and the sequence survives restarts.
+//
+// The store is opened only for the duration of each operation: LevelDB lets
+// a single process hold a database at a time, and that file lock is what
+// serialises senders across processes.
+package nonces
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// Key layout, per account:
+//
+//	"n" + addr          -> next nonce to hand out
+//	"i" + addr + nonce  -> unix time the nonce was handed out
+//	"r" + addr + nonce  -> nothing, a handed out nonce that was given back
+var (
+	nextPrefix     = []byte("n")
+	issuedPrefix   = []byte("i")
+	releasedPrefix = []byte("r")
+)
+
+// LockTimeout is how long an operation waits for another process to release
+// the store.
+const LockTimeout = 10 * time.Second
+
+// DefaultGracePeriod is how long a handed out nonce may stay unknown to the
+// node before Resync reports it as missing. Until then its holder may still
+// be about to send it, or waiting for an offline signature.
+const DefaultGracePeriod = time.Hour
+
+// NonceReader is the part of ethclient.Client the manager needs.
+type NonceReader interface {
+	// NonceAt with a nil block number returns the nonce after the
+	// transactions already mined.
+	NonceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (uint64, error)
+
+	// PendingNonceAt also counts the transactions in the node's pool.
+	PendingNonceAt(ctx context.Context, account common.Address) (uint64, error)
+}
+
+// DefaultPath is the store shared by the tools in this repository,
+// ~/.ethereum-go-book/nonces.
+func DefaultPath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		home = "."
+	}
+	return filepath.Join(home, ".ethereum-go-book", "nonces")
+}
+
+// Manager hands out nonces. It is safe for concurrent use.
+type Manager struct {
+	// GracePeriod overrides DefaultGracePeriod if set.
+	GracePeriod time.Duration
+
+	path   string
+	reader NonceReader
+	mu     sync.Mutex // serialises operations within the process
+}
+
+// New returns a manager keeping its state in the LevelDB store at path.
+func New(path string, reader NonceReader) *Manager {
+	return &Manager{path: path, reader: reader}
+}
+
+// Next returns the nonce to sign the next transaction of account with and
+// marks it as handed out. Nonces given back with Release are reused first,
+// lowest first, so a failed send doesn't leave a gap. Otherwise it is the
+// larger of the stored next nonce and the node's pending nonce, which picks
+// up transactions sent without the manager.
+func (m *Manager) Next(ctx context.Context, account common.Address) (uint64, error) {
+	var nonce uint64
+	err := m.update(ctx, func(db *leveldb.DB) error {
+		pending, err := m.reader.PendingNonceAt(ctx, account)
+		if err != nil {
+			return err
+		}
+		batch := new(leveldb.Batch)
+
+		released, err := nonceSet(db, releasedPrefix, account)
+		if err != nil {
+			return err
+		}
+		found := false
+		for _, n := range released {
+			batch.Delete(nonceKey(releasedPrefix, account, n))
+			if n >= pending {
+				// Nonces below pending were used by someone else since.
+				nonce, found = n, true
+				break
+			}
+		}
+
+		if !found {
+			next, err := getNext(db, account)
+			if err != nil {
+				return err
+			}
+			nonce = next
+			if pending > nonce {
+				nonce = pending
+			}
+			batch.Put(nextKey(account), encodeUint64(nonce+1))
+		}
+
+		batch.Put(nonceKey(issuedPrefix, account, nonce), encodeUint64(uint64(time.Now().Unix())))
+		return db.Write(batch, nil)
+	})
+	return nonce, err
+}
+
+// Release gives back a nonce that was handed out but never made it to the
+// network, for instance because SendTransaction failed. If it is the last
+// nonce handed out the sequence simply steps back, otherwise it is reused by
+// the next call to Next.
+func (m *Manager) Release(ctx context.Context, account common.Address, nonce uint64) error {
+	return m.update(ctx, func(db *leveldb.DB) error {
+		next, err := getNext(db, account)
+		if err != nil {
+			return err
+		}
+		batch := new(leveldb.Batch)
+		batch.Delete(nonceKey(issuedPrefix, account, nonce))
+		if nonce+1 == next {
+			batch.Put(nextKey(account), encodeUint64(nonce))
+		} else if nonce < next {
+			batch.Put(nonceKey(releasedPrefix, account, nonce), nil)
+		}
+		return db.Write(batch, nil)
+	})
+}
+
+// Abort returns the function a command calls to give up between reserving
+// nonce and sending its transaction: it releases the nonce, so that exiting
+// doesn't leave a gap, and then calls log.Fatal with its arguments.
+func (m *Manager) Abort(account common.Address, nonce uint64) func(v ...interface{}) {
+	return func(v ...interface{}) {
+		if err := m.Release(context.Background(), account, nonce); err != nil {
+			log.Print(err)
+		}
+		log.Fatal(v...)
+	}
+}
+
+// Status compares the manager's view of an account with the node's.
+type Status struct {
+	Account common.Address
+	Mined   uint64 // nonce after the mined transactions
+	Pending uint64 // nonce after the transactions in the node's pool
+	Next    uint64 // next nonce the manager will hand out
+
+	// Gaps are nonces from Pending on that were handed out longer than the
+	// grace period ago and that the node still doesn't know about. Their
+	// transactions were dropped or never sent, and every transaction after
+	// them is stuck until they are filled.
+	Gaps []uint64
+
+	// Reserved are nonces from Pending on handed out within the grace
+	// period. They are still expected to reach the node and are left alone.
+	Reserved []uint64
+
+	// Released are nonces given back and waiting to be reused.
+	Released []uint64
+}
+
+// HasGap reports whether transactions are stuck behind a missing nonce.
+func (s *Status) HasGap() bool {
+	return len(s.Gaps) > 0
+}
+
+func (s *Status) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s: mined %d, pending %d, next %d", s.Account.Hex(), s.Mined, s.Pending, s.Next)
+	if s.HasGap() {
+		fmt.Fprintf(&b, ", gap: nonces %v are missing", s.Gaps)
+	}
+	if len(s.Reserved) > 0 {
+		fmt.Fprintf(&b, ", reserved %v", s.Reserved)
+	}
+	if len(s.Released) > 0 {
+		fmt.Fprintf(&b, ", released %v", s.Released)
+	}
+	return b.String()
+}
+
+// Resync reconciles the store with the node. Records of nonces that have
+// been mined are dropped and the next nonce is raised to the node's pending
+// nonce if transactions were sent without the manager. When rewind is set
+// and there is a gap, the missing nonces are released so that Next hands
+// them out again, lowest first; nonces still within their grace period are
+// never touched. Otherwise the gap is only reported. The returned status is
+// the state before rewinding.
+func (m *Manager) Resync(ctx context.Context, account common.Address, rewind bool) (*Status, error) {
+	var status *Status
+	err := m.update(ctx, func(db *leveldb.DB) error {
+		mined, err := m.reader.NonceAt(ctx, account, nil)
+		if err != nil {
+			return err
+		}
+		pending, err := m.reader.PendingNonceAt(ctx, account)
+		if err != nil {
+			return err
+		}
+		next, err := getNext(db, account)
+		if err != nil {
+			return err
+		}
+		if next < pending {
+			next = pending
+		}
+		status = &Status{Account: account, Mined: mined, Pending: pending, Next: next}
+
+		grace := m.GracePeriod
+		if grace <= 0 {
+			grace = DefaultGracePeriod
+		}
+		deadline := uint64(time.Now().Add(-grace).Unix())
+
+		batch := new(leveldb.Batch)
+		issued, err := nonceSet(db, issuedPrefix, account)
+		if err != nil {
+			return err
+		}
+		for _, n := range issued {
+			switch {
+			case n < mined:
+				batch.Delete(nonceKey(issuedPrefix, account, n))
+			case n >= pending:
+				at, err := db.Get(nonceKey(issuedPrefix, account, n), nil)
+				if err != nil {
+					return err
+				}
+				if binary.BigEndian.Uint64(at) <= deadline {
+					status.Gaps = append(status.Gaps, n)
+				} else {
+					status.Reserved = append(status.Reserved, n)
+				}
+			}
+		}
+		released, err := nonceSet(db, releasedPrefix, account)
+		if err != nil {
+			return err
+		}
+		for _, n := range released {
+			if n < pending {
+				batch.Delete(nonceKey(releasedPrefix, account, n))
+			} else {
+				status.Released = append(status.Released, n)
+			}
+		}
+
+		if rewind {
+			for _, n := range status.Gaps {
+				batch.Delete(nonceKey(issuedPrefix, account, n))
+				batch.Put(nonceKey(releasedPrefix, account, n), nil)
+			}
+		}
+		batch.Put(nextKey(account), encodeUint64(next))
+		return db.Write(batch, nil)
+	})
+	return status, err
+}
+
+// update opens the store, waiting for other processes to release it, and
+// runs fn on it.
+func (m *Manager) update(ctx context.Context, fn func(db *leveldb.DB) error) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(m.path), 0700); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, LockTimeout)
+	defer cancel()
+
+	for {
+		db, err := leveldb.OpenFile(m.path, nil)
+		if err == nil {
+			err = fn(db)
+			if closeErr := db.Close(); err == nil {
+				err = closeErr
+			}
+			return err
+		}
+		if !isLocked(err) {
+			return fmt.Errorf("opening nonce store %s: %v", m.path, err)
+		}
+		// Another process holds the store; try again shortly.
+		select {
+		case <-time.After(50 * time.Millisecond):
+		case <-ctx.Done():
+			return fmt.Errorf("opening nonce store %s: %v", m.path, err)
+		}
+	}
+}
+
+// isLocked reports whether an error from leveldb.OpenFile means another
+// process holds the store. LevelDB locks it with flock on Unix, which fails
+// with EWOULDBLOCK, and on Windows by opening its LOCK file without sharing,
+// which fails with ERROR_SHARING_VIOLATION.
+func isLocked(err error) bool {
+	var errno syscall.Errno
+	if !errors.As(err, &errno) {
+		return false
+	}
+	if runtime.GOOS == "windows" {
+		return errno == errorSharingViolation
+	}
+	return errno == syscall.EWOULDBLOCK || errno == syscall.EAGAIN
+}
+
+const errorSharingViolation = 32
+
+func getNext(db *leveldb.DB, account common.Address) (uint64, error) {
+	val, err := db.Get(nextKey(account), nil)
+	if err == leveldb.ErrNotFound {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint64(val), nil
+}
+
+// nonceSet returns the nonces stored under prefix for account, in ascending
+// order since they are big endian.
+func nonceSet(db *leveldb.DB, prefix []byte, account common.Address) ([]uint64, error) {
+	start := append(append([]byte{}, prefix...), account.Bytes()...)
+	it := db.NewIterator(util.BytesPrefix(start), nil)
+	defer it.Release()
+
+	var nonces []uint64
+	for it.Next() {
+		nonces = append(nonces, binary.BigEndian.Uint64(it.Key()[len(start):]))
+	}
+	return nonces, it.Error()
+}
+
+func nextKey(account common.Address) []byte {
+	return append(append([]byte{}, nextPrefix...), account.Bytes()...)
+}
+
+func nonceKey(prefix []byte, account common.Address, nonce uint64) []byte {
+	key := append(append([]byte{}, prefix...), account.Bytes()...)
+	return append(key, encodeUint64(nonce)...)
+}
+
+func encodeUint64(n uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, n)
+	return b
+}
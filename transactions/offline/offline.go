@@ -0,0 +1,281 @@
+// Package offline splits sending a transaction into steps that can run on
+// different machines, so the private key never has to touch a computer that
+// is online.
+//
+// An online machine builds the transaction, filling in what only the chain
+// knows (nonce, fees, chain ID), and writes it unsigned as JSON. The file is
+// carried to an offline machine, reviewed, and signed there; the signed
+// transaction is written as JSON too, with the raw RLP encoding in hex. An
+// online machine finally broadcasts it.
+//
+// The unsigned file records the signing hash of its transaction, the hash
+// the signature commits to. The signer recomputes it from the fields and
+// refuses to sign when it doesn't match, or doesn't match the hash the
+// reviewer approved: any change to a field after review changes the hash.
+package offline
+
+import (
+	"crypto/ecdsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+var (
+	// ErrModified is returned when the fields of an unsigned transaction
+	// don't hash to the signing hash recorded when it was built.
+	ErrModified = errors.New("transaction fields changed since it was built")
+
+	// ErrNotApproved is returned when the signing hash differs from the one
+	// the reviewer approved.
+	ErrNotApproved = errors.New("signing hash differs from the approved one")
+)
+
+// Unsigned is a transaction ready to be reviewed and signed. Amounts are in
+// wei.
+type Unsigned struct {
+	Type                 uint8           `json:"type"`
+	ChainID              *big.Int        `json:"chainId"`
+	From                 common.Address  `json:"from"`
+	To                   *common.Address `json:"to"`
+	Nonce                uint64          `json:"nonce"`
+	Value                *big.Int        `json:"value"`
+	Gas                  uint64          `json:"gas"`
+	GasPrice             *big.Int        `json:"gasPrice,omitempty"`
+	MaxFeePerGas         *big.Int        `json:"maxFeePerGas,omitempty"`
+	MaxPriorityFeePerGas *big.Int        `json:"maxPriorityFeePerGas,omitempty"`
+	Data                 hexutil.Bytes   `json:"data,omitempty"`
+
+	SigningHash common.Hash `json:"signingHash"`
+}
+
+// NewUnsigned wraps a transaction built for chainID and to be signed by from,
+// recording its signing hash.
+func NewUnsigned(tx *types.Transaction, chainID *big.Int, from common.Address) (*Unsigned, error) {
+	u := &Unsigned{
+		Type:    tx.Type(),
+		ChainID: chainID,
+		From:    from,
+		To:      tx.To(),
+		Nonce:   tx.Nonce(),
+		Value:   tx.Value(),
+		Gas:     tx.Gas(),
+		Data:    tx.Data(),
+	}
+	switch tx.Type() {
+	case types.LegacyTxType:
+		u.GasPrice = tx.GasPrice()
+	case types.DynamicFeeTxType:
+		u.MaxFeePerGas, u.MaxPriorityFeePerGas = tx.GasFeeCap(), tx.GasTipCap()
+	default:
+		return nil, fmt.Errorf("unsupported transaction type %d", tx.Type())
+	}
+
+	hash, err := u.Hash()
+	if err != nil {
+		return nil, err
+	}
+	u.SigningHash = hash
+	return u, nil
+}
+
+// Tx returns the transaction the fields describe.
+func (u *Unsigned) Tx() (*types.Transaction, error) {
+	if u.ChainID == nil || u.Value == nil {
+		return nil, errors.New("chainId and value are required")
+	}
+	switch u.Type {
+	case types.LegacyTxType:
+		if u.GasPrice == nil {
+			return nil, errors.New("gasPrice is required for legacy transactions")
+		}
+		return types.NewTx(&types.LegacyTx{
+			Nonce:    u.Nonce,
+			GasPrice: u.GasPrice,
+			Gas:      u.Gas,
+			To:       u.To,
+			Value:    u.Value,
+			Data:     u.Data,
+		}), nil
+	case types.DynamicFeeTxType:
+		if u.MaxFeePerGas == nil || u.MaxPriorityFeePerGas == nil {
+			return nil, errors.New("maxFeePerGas and maxPriorityFeePerGas are required for dynamic fee transactions")
+		}
+		return types.NewTx(&types.DynamicFeeTx{
+			ChainID:   u.ChainID,
+			Nonce:     u.Nonce,
+			GasTipCap: u.MaxPriorityFeePerGas,
+			GasFeeCap: u.MaxFeePerGas,
+			Gas:       u.Gas,
+			To:        u.To,
+			Value:     u.Value,
+			Data:      u.Data,
+		}), nil
+	default:
+		return nil, fmt.Errorf("unsupported transaction type %d", u.Type)
+	}
+}
+
+// Hash computes the signing hash from the fields.
+func (u *Unsigned) Hash() (common.Hash, error) {
+	tx, err := u.Tx()
+	if err != nil {
+		return common.Hash{}, err
+	}
+	return types.LatestSignerForChainID(u.ChainID).Hash(tx), nil
+}
+
+// Verify checks the fields against the recorded signing hash.
+func (u *Unsigned) Verify() error {
+	hash, err := u.Hash()
+	if err != nil {
+		return err
+	}
+	if hash != u.SigningHash {
+		return ErrModified
+	}
+	return nil
+}
+
+// Describe writes the transaction for a reviewer.
+func (u *Unsigned) Describe(w io.Writer) {
+	fmt.Fprintf(w, "Chain ID:     %v\n", u.ChainID)
+	fmt.Fprintf(w, "From:         %s\n", u.From.Hex())
+	if u.To != nil {
+		fmt.Fprintf(w, "To:           %s\n", u.To.Hex())
+	} else {
+		fmt.Fprintf(w, "To:           (contract creation)\n")
+	}
+	fmt.Fprintf(w, "Value:        %s ETH\n", formatEther(u.Value))
+	fmt.Fprintf(w, "Nonce:        %d\n", u.Nonce)
+	fmt.Fprintf(w, "Gas:          %d\n", u.Gas)
+	var price *big.Int
+	if u.Type == types.DynamicFeeTxType {
+		price = u.MaxFeePerGas
+		fmt.Fprintf(w, "Max fee:      %v wei\n", u.MaxFeePerGas)
+		fmt.Fprintf(w, "Tip:          %v wei\n", u.MaxPriorityFeePerGas)
+	} else {
+		price = u.GasPrice
+		fmt.Fprintf(w, "Gas price:    %v wei\n", u.GasPrice)
+	}
+	if price != nil && u.Value != nil {
+		cost := new(big.Int).Mul(price, new(big.Int).SetUint64(u.Gas))
+		fmt.Fprintf(w, "Max cost:     %s ETH\n", formatEther(cost.Add(cost, u.Value)))
+	}
+	if len(u.Data) > 0 {
+		fmt.Fprintf(w, "Data:         %s\n", u.Data)
+	}
+	fmt.Fprintf(w, "Signing hash: %s\n", u.SigningHash.Hex())
+}
+
+// Sign signs the transaction with key after checking that its fields are
+// unchanged, that approved is its signing hash and that key belongs to the
+// sender named in the file.
+func (u *Unsigned) Sign(key *ecdsa.PrivateKey, approved common.Hash) (*Signed, error) {
+	if err := u.Verify(); err != nil {
+		return nil, err
+	}
+	if approved != u.SigningHash {
+		return nil, ErrNotApproved
+	}
+	if addr := crypto.PubkeyToAddress(key.PublicKey); addr != u.From {
+		return nil, fmt.Errorf("key is for %s, transaction is from %s", addr.Hex(), u.From.Hex())
+	}
+
+	tx, err := u.Tx()
+	if err != nil {
+		return nil, err
+	}
+	signed, err := types.SignTx(tx, types.LatestSignerForChainID(u.ChainID), key)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := signed.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	return &Signed{
+		ChainID: u.ChainID,
+		From:    u.From,
+		To:      u.To,
+		Nonce:   u.Nonce,
+		Value:   u.Value,
+		Hash:    signed.Hash(),
+		Raw:     raw,
+	}, nil
+}
+
+// Signed is a signed transaction ready to broadcast. Only Raw is sent; the
+// other fields repeat what it contains for review, and are checked against
+// it by Tx.
+type Signed struct {
+	ChainID *big.Int        `json:"chainId"`
+	From    common.Address  `json:"from"`
+	To      *common.Address `json:"to"`
+	Nonce   uint64          `json:"nonce"`
+	Value   *big.Int        `json:"value"`
+	Hash    common.Hash     `json:"hash"`
+	Raw     hexutil.Bytes   `json:"raw"`
+}
+
+// Tx decodes the raw transaction and checks it against the other fields.
+func (s *Signed) Tx() (*types.Transaction, error) {
+	tx := new(types.Transaction)
+	if err := tx.UnmarshalBinary(s.Raw); err != nil {
+		return nil, err
+	}
+	from, err := types.Sender(types.LatestSignerForChainID(s.ChainID), tx)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case tx.Hash() != s.Hash:
+		return nil, fmt.Errorf("raw transaction hashes to %s, file says %s", tx.Hash().Hex(), s.Hash.Hex())
+	case from != s.From:
+		return nil, fmt.Errorf("raw transaction is from %s, file says %s", from.Hex(), s.From.Hex())
+	case tx.Nonce() != s.Nonce || s.Value == nil || tx.Value().Cmp(s.Value) != 0:
+		return nil, errors.New("raw transaction nonce or value differ from the file")
+	case (tx.To() == nil) != (s.To == nil) || (tx.To() != nil && *tx.To() != *s.To):
+		return nil, errors.New("raw transaction recipient differs from the file")
+	}
+	return tx, nil
+}
+
+// ReadFile reads JSON from path into v, rejecting unknown fields so a typo
+// in a hand edited file isn't silently ignored.
+func ReadFile(path string, v interface{}) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+	return dec.Decode(v)
+}
+
+// WriteFile writes v to path as indented JSON.
+func WriteFile(path string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
+
+func formatEther(wei *big.Int) string {
+	if wei == nil {
+		return "?"
+	}
+	return new(big.Rat).SetFrac(wei, big.NewInt(params.Ether)).FloatString(18)
+}
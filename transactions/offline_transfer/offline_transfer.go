@@ -0,0 +1,316 @@
+package main
+
+/*
+
+  Offline Signing
+
+  transfer_eth does everything on one machine, so the private key sits on a
+  computer connected to the internet. For keys that matter we split the
+  transfer in three steps, and only the signing step needs the key. It runs
+  on a machine that is never online; files go back and forth on a USB stick.
+
+  1. Online, build the unsigned transaction. The nonce, fees and chain ID
+     come from the node:
+
+  $ go run offline_transfer.go -mode build -from 0x96216849c49358B10257cb55b28eA603c874b05E \
+      -to 0x4592d8f8d7b001e72cb26a73e4fa1806a51ac79d -value 1.5 -out unsigned.json
+
+  2. Offline, review the file and sign it, with a keystore file or a raw key.
+     The reviewer passes the signing hash they approved; if any field
+     changed, the hash doesn't match and nothing is signed:
+
+  $ go run offline_transfer.go -mode review -in unsigned.json
+  $ go run offline_transfer.go -mode sign -in unsigned.json -approve 0x... \
+      -keystore UTC--2018-09-16T02-04-09.069815000Z--9fd3... -password-file pass.txt \
+      -out signed.json
+
+  3. Online, broadcast it:
+
+  $ go run offline_transfer.go -mode broadcast -in signed.json
+
+  The build step reserves the nonce in the shared nonce manager, so the other
+  tools sending from the same account skip it. If the transaction is
+  abandoned, because the file is never signed or the broadcast fails, give
+  the nonce back, or every later transaction from the account gets stuck
+  behind the gap. Only do this if the transaction never reached the network;
+  a nonce that is already mined is refused:
+
+  $ go run offline_transfer.go -mode release -in unsigned.json
+
+*/
+import (
+	"context"
+	"crypto/ecdsa"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"strings"
+
+	"ethereum-go-book/transactions/fees"
+	"ethereum-go-book/transactions/nonces"
+	"ethereum-go-book/transactions/offline"
+
+	"github.com/ethereum/go-ethereum/accounts/keystore"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+func main() {
+	mode := flag.String("mode", "", "\"build\", \"review\", \"sign\", \"broadcast\" or \"release\"")
+	url := flag.String("rpc", "https://rinkeby.infura.io", "JSON-RPC endpoint (build, broadcast and release)")
+	in := flag.String("in", "", "file to read")
+	out := flag.String("out", "", "file to write")
+
+	from := flag.String("from", "", "sender (build)")
+	to := flag.String("to", "", "recipient (build)")
+	value := flag.String("value", "", "amount in ether (build)")
+	chainIDFlag := flag.Int64("chain-id", 4, "EIP-155 chain ID the node must be on (build)")
+	strategyName := flag.String("strategy", "normal", "fee strategy: slow, normal or fast (build)")
+	legacy := flag.Bool("legacy", false, "build a legacy transaction (build)")
+
+	approve := flag.String("approve", "", "signing hash approved during review (sign)")
+	keyHex := flag.String("key", "", "raw private key in hex (sign)")
+	keystorePath := flag.String("keystore", "", "keystore file (sign)")
+	passwordFile := flag.String("password-file", "", "file holding the keystore password (sign)")
+	flag.Parse()
+
+	switch *mode {
+	case "build":
+		if err := checkOut(*out); err != nil {
+			log.Fatal(err)
+		}
+		unsigned, err := build(*url, *from, *to, *value, big.NewInt(*chainIDFlag), *strategyName, *legacy)
+		if err != nil {
+			log.Fatal(err)
+		}
+		unsigned.Describe(os.Stdout)
+		if err := offline.WriteFile(*out, unsigned); err != nil {
+			if err := release(*url, unsigned.From, unsigned.Nonce); err != nil {
+				log.Print(err)
+			}
+			log.Fatal(err)
+		}
+		fmt.Printf("\nunsigned transaction written to %s\n", *out)
+
+	case "review":
+		var unsigned offline.Unsigned
+		if err := offline.ReadFile(*in, &unsigned); err != nil {
+			log.Fatal(err)
+		}
+		unsigned.Describe(os.Stdout)
+		if err := unsigned.Verify(); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println("\nfields match the signing hash; pass it to -approve to sign")
+
+	case "sign":
+		var unsigned offline.Unsigned
+		if err := offline.ReadFile(*in, &unsigned); err != nil {
+			log.Fatal(err)
+		}
+		unsigned.Describe(os.Stdout)
+
+		key, err := loadKey(*keyHex, *keystorePath, *passwordFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		signed, err := unsigned.Sign(key, common.HexToHash(*approve))
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := offline.WriteFile(*out, signed); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("\nsigned transaction %s written to %s\n", signed.Hash.Hex(), *out)
+
+	case "broadcast":
+		var signed offline.Signed
+		if err := offline.ReadFile(*in, &signed); err != nil {
+			log.Fatal(err)
+		}
+		tx, err := signed.Tx()
+		if err != nil {
+			log.Fatal(err)
+		}
+		client, err := ethclient.Dial(*url)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := client.SendTransaction(context.Background(), tx); err != nil {
+			log.Fatalf("%v\nif the transaction can't be sent, give nonce %d back with -mode release -in %s", err, signed.Nonce, *in)
+		}
+		fmt.Printf("tx sent: %s\n", tx.Hash().Hex())
+
+	case "release":
+		from, nonce, err := reserved(*in)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := release(*url, from, nonce); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("nonce %d of %s released\n", nonce, from.Hex())
+
+	default:
+		log.Fatalf("unknown mode %q", *mode)
+	}
+}
+
+// build fills in the nonce, fees and chain ID of a transfer from the node.
+func build(url, from, to, value string, chainID *big.Int, strategyName string, legacy bool) (*offline.Unsigned, error) {
+	if !common.IsHexAddress(from) || !common.IsHexAddress(to) {
+		return nil, errors.New("-from and -to must be hex addresses")
+	}
+	fromAddress, toAddress := common.HexToAddress(from), common.HexToAddress(to)
+
+	ether, ok := new(big.Rat).SetString(value)
+	if !ok || ether.Sign() < 0 {
+		return nil, fmt.Errorf("invalid value %q", value)
+	}
+	wei := ether.Mul(ether, new(big.Rat).SetInt(big.NewInt(params.Ether)))
+	if !wei.IsInt() {
+		return nil, fmt.Errorf("value %q is not a whole number of wei", value)
+	}
+	amount := new(big.Int).Set(wei.Num())
+
+	strategy, err := fees.ParseStrategy(strategyName)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+	client, err := ethclient.Dial(url)
+	if err != nil {
+		return nil, err
+	}
+	nodeChainID, err := client.ChainID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if nodeChainID.Cmp(chainID) != 0 {
+		return nil, fmt.Errorf("node is on chain %v, expected %v", nodeChainID, chainID)
+	}
+
+	const gas = 21000
+	var gasPrice, tip, feeCap *big.Int
+	estimate, err := fees.NewEstimator(client, 0).Estimate(ctx, strategy)
+	switch {
+	case legacy || errors.Is(err, fees.ErrNoBaseFee):
+		if gasPrice, err = client.SuggestGasPrice(ctx); err != nil {
+			return nil, err
+		}
+	case err != nil:
+		return nil, err
+	default:
+		tip, feeCap = estimate.GasTipCap, estimate.GasFeeCap
+	}
+
+	// The nonce is reserved in the shared nonce manager now, since the
+	// transaction will only reach the network later.
+	nonce, err := nonces.New(nonces.DefaultPath(), client).Next(ctx, fromAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	var tx *types.Transaction
+	if gasPrice != nil {
+		tx = types.NewTransaction(nonce, toAddress, amount, gas, gasPrice, nil)
+	} else {
+		tx = types.NewTx(&types.DynamicFeeTx{
+			ChainID:   chainID,
+			Nonce:     nonce,
+			GasTipCap: tip,
+			GasFeeCap: feeCap,
+			Gas:       gas,
+			To:        &toAddress,
+			Value:     amount,
+		})
+	}
+	return offline.NewUnsigned(tx, chainID, fromAddress)
+}
+
+// checkOut fails if the output file can't be written, so that build doesn't
+// reserve a nonce for a transaction that would have nowhere to go.
+func checkOut(path string) error {
+	if path == "" {
+		return errors.New("-out is required")
+	}
+	_, statErr := os.Stat(path)
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	file.Close()
+	if os.IsNotExist(statErr) {
+		return os.Remove(path)
+	}
+	return nil
+}
+
+// release gives a reserved nonce back to the nonce manager. A nonce the
+// node has already mined is refused: the transaction went through after
+// all, and releasing it would make the manager hand it out again.
+func release(url string, from common.Address, nonce uint64) error {
+	ctx := context.Background()
+	client, err := ethclient.Dial(url)
+	if err != nil {
+		return err
+	}
+	mined, err := client.NonceAt(ctx, from, nil)
+	if err != nil {
+		return err
+	}
+	if nonce < mined {
+		return fmt.Errorf("nonce %d of %s is already mined, nothing to release", nonce, from.Hex())
+	}
+	return nonces.New(nonces.DefaultPath(), client).Release(ctx, from, nonce)
+}
+
+// reserved returns the account and nonce of an unsigned or signed
+// transaction file.
+func reserved(path string) (common.Address, uint64, error) {
+	var unsigned offline.Unsigned
+	if err := offline.ReadFile(path, &unsigned); err == nil {
+		return unsigned.From, unsigned.Nonce, nil
+	}
+	var signed offline.Signed
+	if err := offline.ReadFile(path, &signed); err != nil {
+		return common.Address{}, 0, fmt.Errorf("%s is neither an unsigned nor a signed transaction: %v", path, err)
+	}
+	if _, err := signed.Tx(); err != nil {
+		return common.Address{}, 0, err
+	}
+	return signed.From, signed.Nonce, nil
+}
+
+// loadKey reads the signing key from a raw hex key or a keystore file.
+func loadKey(keyHex, keystorePath, passwordFile string) (*ecdsa.PrivateKey, error) {
+	switch {
+	case keyHex != "" && keystorePath != "":
+		return nil, errors.New("use either -key or -keystore")
+	case keyHex != "":
+		return crypto.HexToECDSA(strings.TrimPrefix(keyHex, "0x"))
+	case keystorePath != "":
+		keyJSON, err := os.ReadFile(keystorePath)
+		if err != nil {
+			return nil, err
+		}
+		password, err := os.ReadFile(passwordFile)
+		if err != nil {
+			return nil, err
+		}
+		key, err := keystore.DecryptKey(keyJSON, strings.TrimRight(string(password), "\r\n"))
+		if err != nil {
+			return nil, err
+		}
+		return key.PrivateKey, nil
+	default:
+		return nil, errors.New("-key or -keystore is required to sign")
+	}
+}
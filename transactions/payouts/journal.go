@@ -0,0 +1,124 @@
+package payouts
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+)
+
+var syncWrite = &opt.WriteOptions{Sync: true}
+
+// fileKey holds the hash of the file the journal was written for. Payment
+// keys are 32 byte hashes, so it can't clash with them.
+var fileKey = []byte("file")
+
+// ErrOtherFile is returned by Bind when the journal belongs to another file.
+var ErrOtherFile = errors.New("payouts: journal was written for a different file")
+
+// Status of a payment in the journal.
+type Status string
+
+// A payment goes from signed to sent to mined or failed. A signed or sent
+// payment is rebroadcast on the next run. Replaced means another transaction
+// used the payment's nonce; it is never retried automatically since it can't
+// be told apart from the payment having been sent some other way.
+const (
+	Signed   Status = "signed"
+	Sent     Status = "sent"
+	Mined    Status = "mined"
+	Failed   Status = "failed"
+	Replaced Status = "replaced"
+)
+
+// Done reports whether nothing is left to do for the payment automatically.
+// A replaced payment is done in that sense but may not have been paid, so
+// callers should report it apart from mined and failed ones.
+func (s Status) Done() bool {
+	return s == Mined || s == Failed || s == Replaced
+}
+
+// Entry records what was done for a payment.
+type Entry struct {
+	Row    int            `json:"row"`
+	To     common.Address `json:"to"`
+	Amount *big.Int       `json:"amount"`
+	Nonce  uint64         `json:"nonce"`
+	Hash   common.Hash    `json:"hash"`
+	Raw    hexutil.Bytes  `json:"raw"` // signed transaction, to rebroadcast
+	Status Status         `json:"status"`
+	Error  string         `json:"error,omitempty"`
+}
+
+// Journal is the persistent record of a payout run, keyed by Payment.Key.
+type Journal struct {
+	db *leveldb.DB
+}
+
+// OpenJournal opens or creates the journal in the given directory.
+func OpenJournal(path string) (*Journal, error) {
+	db, err := leveldb.OpenFile(path, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &Journal{db: db}, nil
+}
+
+// Close releases the underlying database.
+func (j *Journal) Close() error {
+	return j.db.Close()
+}
+
+// Bind ties the journal to the file of payments with the given hash, or
+// checks that it is already tied to it. Resuming with an edited file could
+// shift identical rows onto each other's entries, so a journal only ever
+// serves the file it was started with.
+func (j *Journal) Bind(fileHash common.Hash) error {
+	stored, err := j.db.Get(fileKey, nil)
+	if err == leveldb.ErrNotFound {
+		return j.db.Put(fileKey, fileHash.Bytes(), syncWrite)
+	}
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(stored, fileHash.Bytes()) {
+		return ErrOtherFile
+	}
+	return nil
+}
+
+// Get returns the entry of a payment, or nil if it hasn't been signed yet.
+func (j *Journal) Get(p *Payment) (*Entry, error) {
+	val, err := j.db.Get(p.Key(), nil)
+	if err == leveldb.ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var e Entry
+	if err := json.Unmarshal(val, &e); err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+// Put records the entry of a payment. The write is synced to disk before
+// Put returns, so an entry written before broadcasting survives a crash.
+func (j *Journal) Put(p *Payment, e *Entry) error {
+	val, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return j.db.Put(p.Key(), val, syncWrite)
+}
+
+// Delete forgets a payment, which is then signed afresh on the next run.
+func (j *Journal) Delete(p *Payment) error {
+	return j.db.Delete(p.Key(), syncWrite)
+}
@@ -0,0 +1,206 @@
+package payouts
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"fmt"
+	"math/big"
+	"time"
+
+	"ethereum-go-book/transactions/nonces"
+	"ethereum-go-book/transactions/txtrack"
+
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// TransferGas is the gas of a plain ether transfer to an account without
+// code.
+const TransferGas = 21000
+
+// Fees are the fees every payment offers: GasPrice for legacy transactions,
+// or GasTipCap and GasFeeCap for dynamic fee ones.
+type Fees struct {
+	GasPrice  *big.Int
+	GasTipCap *big.Int
+	GasFeeCap *big.Int
+}
+
+// MaxCost returns the most a single payment of amount can cost.
+func (f *Fees) MaxCost(amount *big.Int) *big.Int {
+	price := f.GasPrice
+	if f.GasFeeCap != nil {
+		price = f.GasFeeCap
+	}
+	cost := new(big.Int).Mul(price, big.NewInt(TransferGas))
+	return cost.Add(cost, amount)
+}
+
+// Payer sends payments and keeps the journal up to date.
+type Payer struct {
+	backend txtrack.Backend
+	journal *Journal
+	nonces  *nonces.Manager
+	tracker *txtrack.Tracker
+	key     *ecdsa.PrivateKey
+	signer  types.Signer
+	chainID *big.Int
+	fees    *Fees
+}
+
+// NewPayer returns a payer sending from the account of key.
+func NewPayer(backend txtrack.Backend, journal *Journal, manager *nonces.Manager, key *ecdsa.PrivateKey, chainID *big.Int, fees *Fees) *Payer {
+	from := crypto.PubkeyToAddress(key.PublicKey)
+	return &Payer{
+		backend: backend,
+		journal: journal,
+		nonces:  manager,
+		tracker: txtrack.NewTracker(backend, from, 0),
+		key:     key,
+		signer:  types.LatestSignerForChainID(chainID),
+		chainID: chainID,
+		fees:    fees,
+	}
+}
+
+// Send broadcasts a payment, or rebroadcasts it if the journal shows it was
+// signed before, and returns its journal entry. Payments the journal shows
+// as done are returned as they are.
+func (p *Payer) Send(ctx context.Context, payment *Payment) (*Entry, error) {
+	entry, err := p.journal.Get(payment)
+	if err != nil {
+		return nil, err
+	}
+	if entry != nil {
+		if entry.Status.Done() {
+			return entry, nil
+		}
+		return p.resend(ctx, payment, entry)
+	}
+
+	from := crypto.PubkeyToAddress(p.key.PublicKey)
+	nonce, err := p.nonces.Next(ctx, from)
+	if err != nil {
+		return nil, err
+	}
+	tx, err := p.sign(payment, nonce)
+	if err != nil {
+		p.nonces.Release(ctx, from, nonce)
+		return nil, err
+	}
+	raw, err := tx.MarshalBinary()
+	if err != nil {
+		p.nonces.Release(ctx, from, nonce)
+		return nil, err
+	}
+
+	// Journal first: from here on the payment is never signed again, only
+	// this exact transaction rebroadcast.
+	entry = &Entry{
+		Row:    payment.Row,
+		To:     payment.To,
+		Amount: payment.Amount,
+		Nonce:  nonce,
+		Hash:   tx.Hash(),
+		Raw:    raw,
+		Status: Signed,
+	}
+	if err := p.journal.Put(payment, entry); err != nil {
+		p.nonces.Release(ctx, from, nonce)
+		return nil, err
+	}
+
+	if err := p.backend.SendTransaction(ctx, tx); err != nil {
+		// If the node doesn't have the transaction, nothing was paid: forget
+		// it and hand the nonce back, so the next run starts over cleanly.
+		status, checkErr := p.tracker.Check(ctx, tx)
+		if checkErr == nil && status.State == txtrack.Dropped {
+			if delErr := p.journal.Delete(payment); delErr == nil {
+				p.nonces.Release(ctx, from, nonce)
+			}
+			return nil, err
+		}
+		entry.Error = err.Error()
+		return entry, p.journal.Put(payment, entry)
+	}
+
+	entry.Status = Sent
+	return entry, p.journal.Put(payment, entry)
+}
+
+// resend rebroadcasts the journalled transaction of a payment, unless it
+// was mined or replaced in the meantime.
+func (p *Payer) resend(ctx context.Context, payment *Payment, entry *Entry) (*Entry, error) {
+	tx := new(types.Transaction)
+	if err := tx.UnmarshalBinary(entry.Raw); err != nil {
+		return nil, fmt.Errorf("row %d: corrupt journal entry: %v", entry.Row, err)
+	}
+
+	status, err := p.tracker.Check(ctx, tx)
+	if err != nil {
+		return nil, err
+	}
+	switch status.State {
+	case txtrack.Dropped:
+		if err := p.backend.SendTransaction(ctx, tx); err != nil {
+			entry.Error = err.Error()
+			return entry, p.journal.Put(payment, entry)
+		}
+		entry.Status, entry.Error = Sent, ""
+	case txtrack.Pending:
+		entry.Status = Sent
+	default:
+		p.update(entry, status)
+	}
+	return entry, p.journal.Put(payment, entry)
+}
+
+// Wait waits up to timeout for a sent payment to be mined and records the
+// outcome.
+func (p *Payer) Wait(ctx context.Context, payment *Payment, entry *Entry, timeout time.Duration) error {
+	tx := new(types.Transaction)
+	if err := tx.UnmarshalBinary(entry.Raw); err != nil {
+		return err
+	}
+	status, err := p.tracker.Wait(ctx, timeout, nil, tx)
+	if err != nil {
+		return err
+	}
+	p.update(entry, status)
+	return p.journal.Put(payment, entry)
+}
+
+func (p *Payer) update(entry *Entry, status *txtrack.Status) {
+	switch status.State {
+	case txtrack.Mined:
+		entry.Status = Mined
+	case txtrack.Failed:
+		entry.Status = Failed
+	case txtrack.Replaced:
+		entry.Status = Replaced
+	}
+}
+
+func (p *Payer) sign(payment *Payment, nonce uint64) (*types.Transaction, error) {
+	var data types.TxData
+	if p.fees.GasFeeCap != nil {
+		data = &types.DynamicFeeTx{
+			ChainID:   p.chainID,
+			Nonce:     nonce,
+			GasTipCap: p.fees.GasTipCap,
+			GasFeeCap: p.fees.GasFeeCap,
+			Gas:       TransferGas,
+			To:        &payment.To,
+			Value:     payment.Amount,
+		}
+	} else {
+		data = &types.LegacyTx{
+			Nonce:    nonce,
+			GasPrice: p.fees.GasPrice,
+			Gas:      TransferGas,
+			To:       &payment.To,
+			Value:    payment.Amount,
+		}
+	}
+	return types.SignNewTx(p.key, p.signer, data)
+}
@@ -0,0 +1,194 @@
+// Package payouts pays many recipients from one account, reading the
+// payments from a CSV file.
+//
+// Every row is validated before anything is sent, so a typo on row 900
+// doesn't leave a run half done. Each payment is then signed with the next
+// nonce and written to a journal before it is broadcast. If the run crashes,
+// running it again finds the signed transaction in the journal and
+// rebroadcasts it, which the network treats as the same transaction, instead
+// of signing a second payment.
+package payouts
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// Unit is the unit amounts are given in.
+type Unit string
+
+// Supported units.
+const (
+	Ether Unit = "ether"
+	Gwei  Unit = "gwei"
+	Wei   Unit = "wei"
+)
+
+var unitWei = map[Unit]*big.Int{
+	Ether: big.NewInt(params.Ether),
+	Gwei:  big.NewInt(params.GWei),
+	Wei:   big.NewInt(1),
+}
+
+// Payment is one validated row.
+type Payment struct {
+	Row        int // 1-based line in the CSV file
+	To         common.Address
+	Amount     *big.Int // in wei
+	Occurrence int      // 1 for the first row paying To this amount, 2 for the second...
+}
+
+// Key identifies the payment in the journal. It covers the recipient, the
+// amount and the occurrence, so identical rows get distinct keys. The
+// journal is bound to one file (see Journal.Bind), which is what keeps
+// the occurrences pointing at the same rows from one run to the next.
+func (p *Payment) Key() []byte {
+	return crypto.Keccak256([]byte(fmt.Sprintf("%s,%s,%d", p.To.Hex(), p.Amount, p.Occurrence)))
+}
+
+// RowError is a problem with one row of the file.
+type RowError struct {
+	Row int
+	Err error
+}
+
+func (e *RowError) Error() string {
+	return fmt.Sprintf("row %d: %v", e.Row, e.Err)
+}
+
+// ValidationError lists every invalid row of a file.
+type ValidationError struct {
+	Rows []*RowError
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, len(e.Rows))
+	for i, row := range e.Rows {
+		msgs[i] = row.Error()
+	}
+	return fmt.Sprintf("%d invalid row(s):\n  %s", len(e.Rows), strings.Join(msgs, "\n  "))
+}
+
+// ReadCSV reads "recipient,amount" rows, with amounts in the given unit. A
+// first row that doesn't start with an address is taken as a header and
+// skipped; empty lines are ignored. All rows are checked and every problem
+// is reported at once in a *ValidationError.
+func ReadCSV(r io.Reader, unit Unit) ([]*Payment, error) {
+	scale, ok := unitWei[unit]
+	if !ok {
+		return nil, fmt.Errorf("unknown unit %q", unit)
+	}
+
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	var (
+		payments []*Payment
+		invalid  []*RowError
+		seen     = make(map[string]int)
+	)
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		row, _ := reader.FieldPos(0)
+		if row == 1 && len(record) > 0 && !strings.HasPrefix(strings.TrimSpace(record[0]), "0x") {
+			continue // header
+		}
+
+		payment, err := parseRow(record, scale)
+		if err != nil {
+			invalid = append(invalid, &RowError{Row: row, Err: err})
+			continue
+		}
+		payment.Row = row
+		content := payment.To.Hex() + "," + payment.Amount.String()
+		seen[content]++
+		payment.Occurrence = seen[content]
+		payments = append(payments, payment)
+	}
+
+	if len(invalid) > 0 {
+		return nil, &ValidationError{Rows: invalid}
+	}
+	if len(payments) == 0 {
+		return nil, errors.New("no payments in file")
+	}
+	return payments, nil
+}
+
+func parseRow(record []string, scale *big.Int) (*Payment, error) {
+	if len(record) != 2 {
+		return nil, fmt.Errorf("want 2 fields (recipient, amount), have %d", len(record))
+	}
+	addr, amount := strings.TrimSpace(record[0]), strings.TrimSpace(record[1])
+
+	if !common.IsHexAddress(addr) || !strings.HasPrefix(addr, "0x") {
+		return nil, fmt.Errorf("invalid recipient %q", addr)
+	}
+	to := common.HexToAddress(addr)
+	if to == (common.Address{}) {
+		return nil, errors.New("recipient is the zero address")
+	}
+
+	value, ok := new(big.Rat).SetString(amount)
+	if !ok {
+		return nil, fmt.Errorf("invalid amount %q", amount)
+	}
+	value.Mul(value, new(big.Rat).SetInt(scale))
+	if !value.IsInt() {
+		return nil, fmt.Errorf("amount %q is not a whole number of wei", amount)
+	}
+	if value.Sign() <= 0 {
+		return nil, fmt.Errorf("amount %q is not positive", amount)
+	}
+	return &Payment{To: to, Amount: new(big.Int).Set(value.Num())}, nil
+}
+
+// Total returns the sum of the amounts.
+func Total(payments []*Payment) *big.Int {
+	total := new(big.Int)
+	for _, p := range payments {
+		total.Add(total, p.Amount)
+	}
+	return total
+}
+
+// CodeReader is the part of ethclient.Client CheckRecipients needs.
+type CodeReader interface {
+	CodeAt(ctx context.Context, account common.Address, blockNumber *big.Int) ([]byte, error)
+}
+
+// CheckRecipients rejects payments to contracts: they are sent with the gas
+// of a plain transfer, which isn't enough to run a contract's receive
+// function, and many contracts can't get ether back out anyway.
+func CheckRecipients(ctx context.Context, reader CodeReader, payments []*Payment) error {
+	var invalid []*RowError
+	for _, p := range payments {
+		code, err := reader.CodeAt(ctx, p.To, nil)
+		if err != nil {
+			return err
+		}
+		if len(code) > 0 {
+			invalid = append(invalid, &RowError{Row: p.Row, Err: fmt.Errorf("recipient %s is a contract", p.To.Hex())})
+		}
+	}
+	if len(invalid) > 0 {
+		return &ValidationError{Rows: invalid}
+	}
+	return nil
+}
@@ -0,0 +1,259 @@
+// Package preflight checks a signed transaction against the chain before it
+// is broadcast, catching the mistakes that would otherwise only show up as a
+// failed or lost transaction: signing for the wrong chain, not being able to
+// pay for it, sending ether to a contract or calldata to an account without
+// code, absurd fees, and calls that revert.
+package preflight
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"math/big"
+	"strings"
+
+	ethereum "github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// Backend is the part of ethclient.Client the checks need.
+type Backend interface {
+	ChainID(ctx context.Context) (*big.Int, error)
+	HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error)
+	PendingBalanceAt(ctx context.Context, account common.Address) (*big.Int, error)
+	PendingCodeAt(ctx context.Context, account common.Address) ([]byte, error)
+	PendingCallContract(ctx context.Context, msg ethereum.CallMsg) ([]byte, error)
+}
+
+// Config is what the transaction is checked against.
+type Config struct {
+	ChainID *big.Int // the network the transaction is meant for
+
+	// MaxFeeCap bounds the max fee per gas, or gas price, the transaction
+	// may offer. Nil disables the check.
+	MaxFeeCap *big.Int
+
+	// AllowContract allows sending ether without calldata to a contract,
+	// which most contracts reject and some silently keep.
+	AllowContract bool
+}
+
+// Status is the outcome of a single check.
+type Status int
+
+// The possible outcomes, in increasing order of severity.
+const (
+	Pass Status = iota
+	Warn
+	Fail
+)
+
+func (s Status) String() string {
+	switch s {
+	case Pass:
+		return "ok"
+	case Warn:
+		return "warning"
+	default:
+		return "FAILED"
+	}
+}
+
+// Check is the result of one check.
+type Check struct {
+	Name   string
+	Status Status
+	Detail string
+}
+
+// Report is the result of all checks on a transaction.
+type Report struct {
+	Tx      *types.Transaction
+	From    common.Address
+	Balance *big.Int
+	BaseFee *big.Int // nil before London
+	Checks  []Check
+}
+
+// OK reports whether no check failed. Warnings still allow sending.
+func (r *Report) OK() bool {
+	for _, c := range r.Checks {
+		if c.Status == Fail {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *Report) add(name string, status Status, format string, args ...interface{}) {
+	r.Checks = append(r.Checks, Check{Name: name, Status: status, Detail: fmt.Sprintf(format, args...)})
+}
+
+// Run checks tx, sent from from, against the chain. An error is returned
+// only when the node can't be queried; failed checks are in the report.
+func Run(ctx context.Context, b Backend, cfg Config, tx *types.Transaction, from common.Address) (*Report, error) {
+	r := &Report{Tx: tx, From: from}
+
+	// The chain ID the node reports is the one EIP-155 signatures must
+	// commit to; the network ID is a different, p2p level, number that
+	// happens to be equal on some networks only.
+	chainID, err := b.ChainID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case chainID.Cmp(cfg.ChainID) != 0:
+		r.add("chain", Fail, "node is on chain %v, expected %v", chainID, cfg.ChainID)
+	case tx.Protected() && tx.ChainId().Cmp(chainID) != 0:
+		r.add("chain", Fail, "transaction is signed for chain %v, node is on chain %v", tx.ChainId(), chainID)
+	case !tx.Protected():
+		r.add("chain", Warn, "transaction is not replay protected (no chain ID)")
+	default:
+		r.add("chain", Pass, "chain %v", chainID)
+	}
+
+	// Cost is value + gas * max fee per gas, the most the transaction can
+	// take from the sender.
+	if r.Balance, err = b.PendingBalanceAt(ctx, from); err != nil {
+		return nil, err
+	}
+	if r.Balance.Cmp(tx.Cost()) < 0 {
+		r.add("balance", Fail, "balance %s ETH is below value plus max fee %s ETH", formatEther(r.Balance), formatEther(tx.Cost()))
+	} else {
+		r.add("balance", Pass, "balance %s ETH covers value plus max fee %s ETH", formatEther(r.Balance), formatEther(tx.Cost()))
+	}
+
+	if to := tx.To(); to != nil {
+		code, err := b.PendingCodeAt(ctx, *to)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case len(code) > 0 && len(tx.Data()) == 0 && !cfg.AllowContract:
+			r.add("recipient", Fail, "%s is a contract, plain transfers to it may be rejected or lost", to.Hex())
+		case len(code) > 0:
+			r.add("recipient", Pass, "%s is a contract", to.Hex())
+		case len(tx.Data()) > 0:
+			r.add("recipient", Fail, "%s has no code but the transaction carries calldata", to.Hex())
+		default:
+			r.add("recipient", Pass, "%s is an externally owned account", to.Hex())
+		}
+	}
+
+	head, err := b.HeaderByNumber(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	r.BaseFee = head.BaseFee
+	r.checkFees(cfg)
+
+	// Run the transaction against the pending state without sending it. A
+	// revert here will almost certainly revert once mined too, and still
+	// cost the gas.
+	msg := ethereum.CallMsg{
+		From:  from,
+		To:    tx.To(),
+		Gas:   tx.Gas(),
+		Value: tx.Value(),
+		Data:  tx.Data(),
+	}
+	if tx.Type() == types.DynamicFeeTxType {
+		msg.GasFeeCap, msg.GasTipCap = tx.GasFeeCap(), tx.GasTipCap()
+	} else {
+		msg.GasPrice = tx.GasPrice()
+	}
+	if _, err := b.PendingCallContract(ctx, msg); err != nil {
+		r.add("dry run", Fail, "%s", revertReason(err))
+	} else {
+		r.add("dry run", Pass, "eth_call succeeded")
+	}
+
+	return r, nil
+}
+
+func (r *Report) checkFees(cfg Config) {
+	tx := r.Tx
+	feeCap := tx.GasFeeCap() // the gas price for legacy transactions
+
+	switch {
+	case cfg.MaxFeeCap != nil && feeCap.Cmp(cfg.MaxFeeCap) > 0:
+		r.add("fees", Fail, "max fee %s gwei is above the limit of %s gwei", formatGwei(feeCap), formatGwei(cfg.MaxFeeCap))
+	case tx.GasTipCap().Cmp(feeCap) > 0:
+		r.add("fees", Fail, "tip %s gwei is above max fee %s gwei", formatGwei(tx.GasTipCap()), formatGwei(feeCap))
+	case r.BaseFee != nil && feeCap.Cmp(r.BaseFee) < 0:
+		r.add("fees", Warn, "max fee %s gwei is below the current base fee %s gwei, the transaction waits until it drops",
+			formatGwei(feeCap), formatGwei(r.BaseFee))
+	default:
+		r.add("fees", Pass, "max fee %s gwei, tip %s gwei", formatGwei(feeCap), formatGwei(tx.GasTipCap()))
+	}
+}
+
+// revertReason returns the reason string of a reverted call when the node
+// returned one.
+func revertReason(err error) string {
+	dataErr, ok := err.(interface{ ErrorData() interface{} })
+	if !ok {
+		return err.Error()
+	}
+	data, ok := dataErr.ErrorData().(string)
+	if !ok {
+		return err.Error()
+	}
+	reason, unpackErr := abi.UnpackRevert(common.FromHex(data))
+	if unpackErr != nil {
+		return err.Error()
+	}
+	return "reverted: " + reason
+}
+
+// Print writes a summary of the transaction and the checks.
+func (r *Report) Print(w io.Writer) {
+	tx := r.Tx
+	fmt.Fprintf(w, "From:      %s\n", r.From.Hex())
+	if tx.To() != nil {
+		fmt.Fprintf(w, "To:        %s\n", tx.To().Hex())
+	} else {
+		fmt.Fprintf(w, "To:        (contract creation)\n")
+	}
+	fmt.Fprintf(w, "Value:     %s ETH\n", formatEther(tx.Value()))
+	fmt.Fprintf(w, "Nonce:     %d\n", tx.Nonce())
+	fmt.Fprintf(w, "Gas:       %d\n", tx.Gas())
+	if tx.Type() == types.DynamicFeeTxType {
+		fmt.Fprintf(w, "Max fee:   %s gwei\n", formatGwei(tx.GasFeeCap()))
+		fmt.Fprintf(w, "Tip:       %s gwei\n", formatGwei(tx.GasTipCap()))
+	} else {
+		fmt.Fprintf(w, "Gas price: %s gwei\n", formatGwei(tx.GasPrice()))
+	}
+	fmt.Fprintf(w, "Max cost:  %s ETH\n", formatEther(tx.Cost()))
+	if len(tx.Data()) > 0 {
+		fmt.Fprintf(w, "Data:      %d bytes\n", len(tx.Data()))
+	}
+	fmt.Fprintln(w)
+	for _, c := range r.Checks {
+		fmt.Fprintf(w, "  [%-7s] %-9s %s\n", c.Status, c.Name, c.Detail)
+	}
+}
+
+// Confirm asks on out whether to go ahead and reads the answer from in.
+// Anything but "y" or "yes" declines.
+func Confirm(in io.Reader, out io.Writer, question string) bool {
+	fmt.Fprintf(out, "%s [y/N] ", question)
+	answer, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil && answer == "" {
+		return false
+	}
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+func formatEther(wei *big.Int) string {
+	return new(big.Rat).SetFrac(wei, big.NewInt(params.Ether)).FloatString(18)
+}
+
+func formatGwei(wei *big.Int) string {
+	return new(big.Rat).SetFrac(wei, big.NewInt(params.GWei)).FloatString(9)
+}
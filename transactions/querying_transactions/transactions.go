@@ -2,17 +2,58 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"log"
 	"math/big"
+	"strings"
+
+	"ethereum-go-book/transactions/calldata"
+	"ethereum-go-book/transactions/fees"
+	"ethereum-go-book/transactions/receipts"
+	"ethereum-go-book/transactions/txinspect"
 
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
 )
 
 func main() {
-	client, err := ethclient.Dial("https://mainnet.infura.io")
+	abiFiles := flag.String("abi", "", "comma separated list of extra JSON ABI files to decode input with")
+	selectors := flag.String("selectors", "", "file of \"<selector> <signature>\" lines to decode unknown input with")
+	flag.Parse()
+
+	rpcClient, err := rpc.Dial("https://mainnet.infura.io")
+	if err != nil {
+		log.Fatal(err)
+	}
+	client := ethclient.NewClient(rpcClient)
+
+	// The input data of a contract call is ABI encoded. To show it as a function
+	// call we need the ABI of the contract that was called. The registry knows
+	// the Store and ERC20 contracts from the previous sections and can be given
+	// more ABIs, or a table of bare function signatures.
+	registry := calldata.Default()
+	if *abiFiles != "" {
+		for _, file := range strings.Split(*abiFiles, ",") {
+			if err := registry.AddABIFile(file); err != nil {
+				log.Fatal(err)
+			}
+		}
+	}
+	if *selectors != "" {
+		if err := registry.AddSelectorsFile(*selectors); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// The sender of a transaction is recovered from its signature, and the signer
+	// to use depends on the chain ID and on the transaction type, so we need to
+	// know which chain we're talking to. Note that this is eth_chainId, which is
+	// not always the same as the network ID returned by NetworkID.
+	chainID, err := client.ChainID(context.Background())
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -23,25 +64,72 @@ func main() {
 		log.Fatal(err)
 	}
 
+	// Rather than asking for the receipts one transaction at a time, we fetch
+	// all of them up front. The fetcher uses eth_getBlockReceipts when the node
+	// supports it and batches of TransactionReceipt calls otherwise. The results
+	// are in the same order as block.Transactions().
+	fetcher := receipts.NewFetcher(rpcClient, receipts.DefaultConcurrency)
+	results, err := fetcher.BlockReceipts(context.Background(), block)
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	for idx, tx := range block.Transactions() {
-		fmt.Printf("\n%d.\tTx Hash: %v\n", idx+1, tx.Hash().Hex())
-		fmt.Printf("\tTx Value: %s\n", tx.Value().String())
-		fmt.Printf("\tTx Gas: %d\n", tx.Gas())
-		fmt.Printf("\tTx Gas Price: %d\n", tx.GasPrice().Uint64())
-		fmt.Printf("\tTx Nonce: %d\n", tx.Nonce())
-		fmt.Printf("\tTx Data: %v\n", tx.Data())
-		fmt.Printf("\tTx To: %v\n", tx.To().Hex())
-
-		if msg, err := tx.AsMessage(types.HomesteadSigner{}); err == nil {
-			fmt.Printf("\tTx Message From: %v\n", msg.From().Hex())
+		receipt := results[idx].Receipt
+		if results[idx].Err != nil {
+			fmt.Printf("\n%d.\tTx Hash: %v\n", idx+1, tx.Hash().Hex())
+			fmt.Printf("\tTx Receipt Error: %v\n", results[idx].Err)
+			continue
 		}
 
-		receipt, err := client.TransactionReceipt(context.Background(), tx.Hash())
+		info, err := txinspect.Inspect(tx, chainID, receipt)
 		if err != nil {
-			log.Fatal(err)
+			fmt.Printf("\n%d.\tTx Hash: %v\n", idx+1, tx.Hash().Hex())
+			fmt.Printf("\tTx Error: %v\n", err)
+			continue
+		}
+
+		fmt.Printf("\n%d.\tTx Hash: %v\n", idx+1, info.Hash.Hex())
+		fmt.Printf("\tTx Type: %s\n", info.TypeName())
+		fmt.Printf("\tTx Value: %s\n", info.Value.String())
+		fmt.Printf("\tTx Gas: %d\n", info.Gas)
+		fmt.Printf("\tTx Gas Price: %s\n", info.GasPrice.String())
+		if info.Type == types.DynamicFeeTxType {
+			fmt.Printf("\tTx Max Fee Per Gas: %s\n", info.GasFeeCap.String())
+			fmt.Printf("\tTx Max Priority Fee Per Gas: %s\n", info.GasTipCap.String())
+		}
+		for _, tuple := range info.AccessList {
+			fmt.Printf("\tTx Access List: %v %d storage keys\n", tuple.Address.Hex(), len(tuple.StorageKeys))
+		}
+		fmt.Printf("\tTx Nonce: %d\n", info.Nonce)
+
+		// The data of a contract creation is init code, not a call, so only
+		// calls are decoded.
+		if info.IsCreation() {
+			fmt.Printf("\tTx Init Code: %d bytes\n", len(info.Data))
+		} else if call, err := registry.Decode(info.Data); err != nil {
+			fmt.Printf("\tTx Data: %v\n", hexutil.Encode(info.Data))
+		} else if call != nil {
+			fmt.Printf("\tTx Input: %v\n", call)
 		}
 
+		// Contract creations have no recipient, tx.To() is nil for them. The
+		// address of the new contract is found in the receipt instead.
+		if info.IsCreation() {
+			fmt.Printf("\tTx Contract Created: %v\n", info.ContractAddress.Hex())
+		} else {
+			fmt.Printf("\tTx To: %v\n", info.To.Hex())
+		}
+
+		fmt.Printf("\tTx Message From: %v\n", info.From.Hex())
 		fmt.Printf("\tTx Receipt Status: %d\n", receipt.Status)
+
+		// What the transaction paid depends on the gas it used and, since London,
+		// on the block's base fee, which is burnt rather than paid to the miner.
+		fee := fees.ForTx(block.Header(), tx, receipt, info.From)
+		fmt.Printf("\tTx Effective Gas Price: %s\n", fee.EffectiveGasPrice.String())
+		fmt.Printf("\tTx Gas Used: %d / %d\n", fee.GasUsed, fee.GasLimit)
+		fmt.Printf("\tTx Fee: %s (burnt %s, tip %s)\n", fee.Fee.String(), fee.Burnt.String(), fee.Tip.String())
 	}
 
 	fmt.Println("+++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++++")
@@ -0,0 +1,190 @@
+// Package receipts fetches all the receipts of a block at once.
+//
+// Asking for the receipts of a block one TransactionReceipt call at a time
+// costs a round trip per transaction. Nodes that implement
+// eth_getBlockReceipts return them all in a single call; for the others the
+// per-transaction calls are sent as JSON-RPC batches, several batches at a
+// time.
+package receipts
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	ethereum "github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+const (
+	// DefaultConcurrency is the number of batches in flight when falling
+	// back to TransactionReceipt calls.
+	DefaultConcurrency = 4
+
+	// DefaultBatchSize is the number of TransactionReceipt calls per batch.
+	DefaultBatchSize = 50
+)
+
+// Result is the receipt of a single transaction, or the error that prevented
+// fetching it.
+type Result struct {
+	Receipt *types.Receipt
+	Err     error
+}
+
+// Fetcher fetches block receipts. It remembers whether the node supports
+// eth_getBlockReceipts so that an unsupported node is only asked once. A
+// Fetcher is safe for concurrent use.
+type Fetcher struct {
+	client      *rpc.Client
+	concurrency int
+	batchSize   int
+
+	mu          sync.Mutex
+	unsupported bool // node rejected eth_getBlockReceipts
+}
+
+// NewFetcher returns a fetcher using the given RPC connection. A non-positive
+// concurrency selects DefaultConcurrency.
+func NewFetcher(client *rpc.Client, concurrency int) *Fetcher {
+	if concurrency <= 0 {
+		concurrency = DefaultConcurrency
+	}
+	return &Fetcher{client: client, concurrency: concurrency, batchSize: DefaultBatchSize}
+}
+
+// BlockReceipts returns the receipts of every transaction in block, aligned
+// with block.Transactions(): result i is the receipt of transaction i. A
+// receipt that could not be fetched carries its own error rather than
+// failing the whole block; the returned error is only set when the context
+// was cancelled.
+func (f *Fetcher) BlockReceipts(ctx context.Context, block *types.Block) ([]Result, error) {
+	txs := block.Transactions()
+	results := make([]Result, len(txs))
+	if len(txs) == 0 {
+		return results, nil
+	}
+
+	missing := make([]int, 0, len(txs))
+	byHash, err := f.blockReceipts(ctx, block.Hash())
+	for i, tx := range txs {
+		if receipt, ok := byHash[tx.Hash()]; ok {
+			results[i].Receipt = receipt
+		} else {
+			missing = append(missing, i)
+		}
+	}
+	if err != nil && ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if len(missing) == 0 {
+		return results, nil
+	}
+
+	f.transactionReceipts(ctx, txs, missing, results)
+	return results, ctx.Err()
+}
+
+// blockReceipts calls eth_getBlockReceipts and indexes the receipts by
+// transaction hash. Receipts of another block are dropped, so that the
+// caller falls back to fetching them one by one.
+func (f *Fetcher) blockReceipts(ctx context.Context, hash common.Hash) (map[common.Hash]*types.Receipt, error) {
+	f.mu.Lock()
+	unsupported := f.unsupported
+	f.mu.Unlock()
+	if unsupported {
+		return nil, nil
+	}
+
+	var receipts []*types.Receipt
+	if err := f.client.CallContext(ctx, &receipts, "eth_getBlockReceipts", hash.Hex()); err != nil {
+		if isUnsupported(err) {
+			f.mu.Lock()
+			f.unsupported = true
+			f.mu.Unlock()
+		}
+		return nil, err
+	}
+
+	byHash := make(map[common.Hash]*types.Receipt, len(receipts))
+	for _, receipt := range receipts {
+		if receipt != nil && receipt.BlockHash == hash {
+			byHash[receipt.TxHash] = receipt
+		}
+	}
+	return byHash, nil
+}
+
+// transactionReceipts fetches the receipts of txs[i] for every i in indexes
+// with batched eth_getTransactionReceipt calls, storing them in results.
+func (f *Fetcher) transactionReceipts(ctx context.Context, txs types.Transactions, indexes []int, results []Result) {
+	var (
+		wg  sync.WaitGroup
+		sem = make(chan struct{}, f.concurrency)
+	)
+	for start := 0; start < len(indexes); start += f.batchSize {
+		end := start + f.batchSize
+		if end > len(indexes) {
+			end = len(indexes)
+		}
+		batch := indexes[start:end]
+
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			for _, i := range batch {
+				results[i].Err = ctx.Err()
+			}
+			continue
+		}
+
+		wg.Add(1)
+		go func(batch []int) {
+			defer func() { <-sem; wg.Done() }()
+
+			elems := make([]rpc.BatchElem, len(batch))
+			receipts := make([]*types.Receipt, len(batch))
+			for j, i := range batch {
+				elems[j] = rpc.BatchElem{
+					Method: "eth_getTransactionReceipt",
+					Args:   []interface{}{txs[i].Hash()},
+					Result: &receipts[j],
+				}
+			}
+			err := f.client.BatchCallContext(ctx, elems)
+
+			// Each batch writes a disjoint set of result slots.
+			for j, i := range batch {
+				switch {
+				case err != nil:
+					results[i].Err = err
+				case elems[j].Error != nil:
+					results[i].Err = elems[j].Error
+				case receipts[j] == nil:
+					results[i].Err = ethereum.NotFound
+				default:
+					results[i].Receipt = receipts[j]
+				}
+				if results[i].Err != nil {
+					results[i].Err = fmt.Errorf("receipt of %v: %w", txs[i].Hash().Hex(), results[i].Err)
+				}
+			}
+		}(batch)
+	}
+	wg.Wait()
+}
+
+// isUnsupported reports whether err means the node does not implement the
+// method, as opposed to failing to serve this particular request.
+func isUnsupported(err error) bool {
+	var rpcErr rpc.Error
+	if errors.As(err, &rpcErr) && rpcErr.ErrorCode() == -32601 {
+		return true
+	}
+	msg := strings.ToLower(err.Error())
+	return strings.Contains(msg, "does not exist") || strings.Contains(msg, "not available") || strings.Contains(msg, "not supported")
+}
@@ -0,0 +1,176 @@
+// Package sinks delivers the records produced by the streaming tools, such
+// as new blocks and contract events, to where they are consumed.
+//
+// Every sink receives records one at a time, in order. Multi fans a record
+// out to several sinks, so a subscriber can for example print to stdout,
+// keep a rotating file and call a webhook at the same time.
+package sinks
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Record is a single item of a stream.
+type Record struct {
+	Kind string      `json:"kind"` // "block", "log", ...
+	Time time.Time   `json:"time"` // when the record was produced
+	Data interface{} `json:"data"`
+}
+
+// NewRecord returns a record of the given kind, stamped with the current time.
+func NewRecord(kind string, data interface{}) *Record {
+	return &Record{Kind: kind, Time: time.Now().UTC(), Data: data}
+}
+
+// Sink consumes records.
+type Sink interface {
+	// Send delivers a record. It returns once the record is delivered or
+	// delivery failed for good.
+	Send(ctx context.Context, rec *Record) error
+
+	// Close flushes and releases the sink.
+	Close() error
+}
+
+// JSONLines writes every record as one line of JSON.
+type JSONLines struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// NewJSONLines returns a sink writing JSON lines to w, typically os.Stdout.
+func NewJSONLines(w io.Writer) *JSONLines {
+	return &JSONLines{enc: json.NewEncoder(w)}
+}
+
+// Send implements Sink.
+func (s *JSONLines) Send(ctx context.Context, rec *Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.enc.Encode(rec)
+}
+
+// Close implements Sink. The writer is not closed, it belongs to the caller.
+func (s *JSONLines) Close() error {
+	return nil
+}
+
+// Chan hands records to a Go channel, for programs that embed a streaming
+// tool and consume its records directly.
+type Chan struct {
+	ch chan<- *Record
+}
+
+// NewChan returns a sink sending to ch. Send blocks until the record is
+// received or the context is cancelled.
+func NewChan(ch chan<- *Record) *Chan {
+	return &Chan{ch: ch}
+}
+
+// Send implements Sink.
+func (s *Chan) Send(ctx context.Context, rec *Record) error {
+	select {
+	case s.ch <- rec:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close implements Sink. The channel is not closed, it belongs to the caller.
+func (s *Chan) Close() error {
+	return nil
+}
+
+// Multi fans records out to several sinks concurrently. Each sink still sees
+// the records in order, since Send waits for all of them before returning.
+type Multi struct {
+	sinks []Sink
+}
+
+// NewMulti returns a sink sending to all the given sinks.
+func NewMulti(sinks ...Sink) *Multi {
+	return &Multi{sinks: sinks}
+}
+
+// Send implements Sink. A failing sink does not stop delivery to the others;
+// their errors are combined.
+func (m *Multi) Send(ctx context.Context, rec *Record) error {
+	errs := make([]error, len(m.sinks))
+
+	var wg sync.WaitGroup
+	for i, sink := range m.sinks {
+		wg.Add(1)
+		go func(i int, sink Sink) {
+			defer wg.Done()
+			errs[i] = sink.Send(ctx, rec)
+		}(i, sink)
+	}
+	wg.Wait()
+
+	return combine(errs)
+}
+
+// Close implements Sink, closing every sink.
+func (m *Multi) Close() error {
+	errs := make([]error, len(m.sinks))
+	for i, sink := range m.sinks {
+		errs[i] = sink.Close()
+	}
+	return combine(errs)
+}
+
+func combine(errs []error) error {
+	var msgs []string
+	for _, err := range errs {
+		if err != nil {
+			msgs = append(msgs, err.Error())
+		}
+	}
+	if len(msgs) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%s", strings.Join(msgs, "; "))
+}
+
+// Open builds a sink from a comma separated list of specs, fanning out to
+// all of them when there is more than one:
+//
+//	stdout                JSON lines on standard output
+//	file:PATH             JSON lines in a rotating file
+//	http://... https://   a webhook, signed with secret when it is not empty
+func Open(specs, secret string) (Sink, error) {
+	var sinks []Sink
+	for _, spec := range strings.Split(specs, ",") {
+		spec = strings.TrimSpace(spec)
+		switch {
+		case spec == "":
+			continue
+		case spec == "stdout":
+			sinks = append(sinks, NewJSONLines(os.Stdout))
+		case strings.HasPrefix(spec, "file:"):
+			file, err := NewFile(strings.TrimPrefix(spec, "file:"), 0, 0)
+			if err != nil {
+				NewMulti(sinks...).Close()
+				return nil, err
+			}
+			sinks = append(sinks, file)
+		case strings.HasPrefix(spec, "http://"), strings.HasPrefix(spec, "https://"):
+			sinks = append(sinks, NewWebhook(WebhookConfig{URL: spec, Secret: secret}))
+		default:
+			NewMulti(sinks...).Close()
+			return nil, fmt.Errorf("unknown sink %q", spec)
+		}
+	}
+	if len(sinks) == 1 {
+		return sinks[0], nil
+	}
+	return NewMulti(sinks...), nil
+}
@@ -0,0 +1,137 @@
+package sinks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// SignatureHeader carries the HMAC-SHA256 of the request body, as
+// "sha256=<hex>", when the webhook has a secret.
+const SignatureHeader = "X-Signature-256"
+
+// Default retry settings of a webhook sink.
+const (
+	DefaultRetries    = 5
+	DefaultMinBackoff = 500 * time.Millisecond
+	DefaultMaxBackoff = 30 * time.Second
+)
+
+// NoRetries turns retries off in WebhookConfig.Retries, where zero selects
+// DefaultRetries.
+const NoRetries = -1
+
+// WebhookConfig configures a webhook sink.
+type WebhookConfig struct {
+	URL    string
+	Secret string // signs every request when set
+
+	Retries    int           // attempts after the first one fails, NoRetries for none
+	MinBackoff time.Duration // delay before the first retry, doubled each time
+	MaxBackoff time.Duration
+	Client     *http.Client
+}
+
+// Webhook POSTs every record as JSON to a URL. Network errors, 5xx and 429
+// responses are retried with exponential backoff; other responses outside
+// 2xx fail the record immediately.
+type Webhook struct {
+	cfg WebhookConfig
+}
+
+// NewWebhook returns a webhook sink. Zero settings use the defaults.
+func NewWebhook(cfg WebhookConfig) *Webhook {
+	switch {
+	case cfg.Retries == 0:
+		cfg.Retries = DefaultRetries
+	case cfg.Retries < 0:
+		cfg.Retries = 0
+	}
+	if cfg.MinBackoff == 0 {
+		cfg.MinBackoff = DefaultMinBackoff
+	}
+	if cfg.MaxBackoff == 0 {
+		cfg.MaxBackoff = DefaultMaxBackoff
+	}
+	if cfg.Client == nil {
+		cfg.Client = &http.Client{Timeout: 30 * time.Second}
+	}
+	return &Webhook{cfg: cfg}
+}
+
+// Send implements Sink.
+func (s *Webhook) Send(ctx context.Context, rec *Record) error {
+	body, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	backoff := s.cfg.MinBackoff
+	for attempt := 0; ; attempt++ {
+		retry, err := s.post(ctx, body)
+		if err == nil {
+			return nil
+		}
+		if !retry || attempt >= s.cfg.Retries {
+			return fmt.Errorf("webhook %s: %v", s.cfg.URL, err)
+		}
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if backoff *= 2; backoff > s.cfg.MaxBackoff {
+			backoff = s.cfg.MaxBackoff
+		}
+	}
+}
+
+// post sends body once and reports whether a failure is worth retrying.
+func (s *Webhook) post(ctx context.Context, body []byte) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, s.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	if s.cfg.Secret != "" {
+		req.Header.Set(SignatureHeader, Sign(s.cfg.Secret, body))
+	}
+
+	resp, err := s.cfg.Client.Do(req)
+	if err != nil {
+		return ctx.Err() == nil, err
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests:
+		return true, fmt.Errorf("HTTP %s", resp.Status)
+	default:
+		return false, fmt.Errorf("HTTP %s", resp.Status)
+	}
+}
+
+// Sign returns the signature header value of body, so receivers can check it
+// by comparing against Sign(secret, body) with hmac.Equal.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Close implements Sink.
+func (s *Webhook) Close() error {
+	return nil
+}
@@ -2,9 +2,13 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"log"
 
+	"ethereum-go-book/transactions/heads"
+	"ethereum-go-book/transactions/sinks"
+
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/ethclient"
 )
@@ -14,28 +18,67 @@ import (
   In this section we'll go over how to set up a subscription
   to get events when there is a new block mined.
 
+  Besides printing them, every block can be handed to one or more sinks,
+  given as a comma separated list:
+
+  $ go run block_subscribe.go -sink stdout,file:blocks.jsonl,https://example.com/hook \
+      -webhook-secret s3cret
+
 */
 
 func main() {
-	// First thing is we need an Ethereum provider that
-	// supports RPC over websockets. In this example
-	// we'll use the infura websocket endpoint.
+	sinkSpecs := flag.String("sink", "", "comma separated sinks: stdout, file:PATH or a webhook URL")
+	secret := flag.String("webhook-secret", "", "key to sign webhook requests with (HMAC-SHA256)")
+	flag.Parse()
+
+	// A sink receives every block as a record. With several of them,
+	// each block is sent to all of them at once.
+
+	sink, err := sinks.Open(*sinkSpecs, *secret)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer sink.Close()
+
+	// The client is only used to fetch full blocks, so a
+	// plain HTTP endpoint will do.
 
-	client, err := ethclient.Dial("wss://ropsten.infura.io/ws")
+	client, err := ethclient.Dial("https://ropsten.infura.io")
 	if err != nil {
 		log.Fatal(err)
 	}
 
+	// Subscriptions need an Ethereum provider that supports RPC over
+	// websockets, in this example the infura websocket endpoint. Given an
+	// HTTP endpoint instead, the follower polls for new heads and we get
+	// them on the same channel.
+	//
+	// A bare client.SubscribeNewHead subscription ends the first time the
+	// websocket connection drops, and the blocks mined until we subscribe
+	// again are simply lost. The head follower wraps the subscription: it
+	// reconnects with exponential backoff, and before handing us a header
+	// it fetches over HTTP every block we haven't seen yet, so we never
+	// see a gap in the chain.
+
+	follower := heads.NewFollower(heads.Config{
+		URL:         "wss://ropsten.infura.io/ws",
+		BackfillURL: "https://ropsten.infura.io",
+		OnReconnect: func(attempt int, err error) {
+			log.Printf("subscription lost (attempt %d): %v", attempt, err)
+		},
+	})
+
 	// Next we'll create a new channel that will be receiving
 	// the latest block headers.
 
 	headers := make(chan *types.Header)
 
-	// Now we call the client's SubscribeNewHead method which
+	// Now we call the follower's SubscribeNewHead method which
 	// takes in the headers channel we just created, which will
-	// return a subscription object.
+	// return a subscription object. It works just like the
+	// client's SubscribeNewHead method.
 
-	sub, err := client.SubscribeNewHead(context.Background(), headers)
+	sub, err := follower.SubscribeNewHead(context.Background(), headers)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -43,7 +86,8 @@ func main() {
 	// The subscription will push new block headers to our channel
 	// so we'll use a select statement to listen for new messages.
 	// The subscription object also contains an error channel that
-	// will send a message in case of a failure with the subscription.
+	// will send a message in case of a failure the follower can't
+	// recover from, such as a reorg deeper than it remembers.
 
 	for {
 		select {
@@ -65,6 +109,17 @@ func main() {
 			fmt.Printf("\tblock.Time().Uint64(): %v\n", block.Time().Uint64())
 			fmt.Printf("\tblock.Nonce(): %v\n", block.Nonce())
 			fmt.Printf("\tBlock Transactions Count: %d\n\n", len(block.Transactions()))
+
+			rec := sinks.NewRecord("block", map[string]interface{}{
+				"hash":         block.Hash().Hex(),
+				"number":       block.Number().Uint64(),
+				"time":         block.Time().Uint64(),
+				"nonce":        block.Nonce(),
+				"transactions": len(block.Transactions()),
+			})
+			if err := sink.Send(context.Background(), rec); err != nil {
+				log.Printf("sink: %v", err)
+			}
 		}
 	}
 }
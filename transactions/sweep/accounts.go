@@ -0,0 +1,78 @@
+package sweep
+
+import (
+	"crypto/ecdsa"
+	"fmt"
+	"os"
+
+	"github.com/ethereum/go-ethereum/accounts/keystore"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/miguelmota/go-ethereum-hdwallet"
+)
+
+// DefaultBasePath is the BIP-44 path of Ethereum accounts; the account index
+// is appended to it.
+const DefaultBasePath = "m/44'/60'/0'/0"
+
+// Account is an account to sweep.
+type Account struct {
+	Address common.Address
+	Key     *ecdsa.PrivateKey
+	Origin  string // keystore file or derivation path
+}
+
+// NewAccount wraps a private key.
+func NewAccount(key *ecdsa.PrivateKey, origin string) *Account {
+	return &Account{Address: crypto.PubkeyToAddress(key.PublicKey), Key: key, Origin: origin}
+}
+
+// FromKeystores decrypts keystore files, all protected by the same password.
+func FromKeystores(paths []string, password string) ([]*Account, error) {
+	accounts := make([]*Account, 0, len(paths))
+	for _, path := range paths {
+		keyJSON, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		key, err := keystore.DecryptKey(keyJSON, password)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		accounts = append(accounts, NewAccount(key.PrivateKey, path))
+	}
+	return accounts, nil
+}
+
+// FromMnemonic derives the accounts basePath/from to basePath/to, inclusive,
+// from a BIP-39 mnemonic.
+func FromMnemonic(mnemonic, basePath string, from, to uint32) ([]*Account, error) {
+	if to < from {
+		return nil, fmt.Errorf("empty account range %d-%d", from, to)
+	}
+	wallet, err := hdwallet.NewFromMnemonic(mnemonic)
+	if err != nil {
+		return nil, err
+	}
+
+	accounts := make([]*Account, 0, to-from+1)
+	for i := from; ; i++ {
+		pathString := fmt.Sprintf("%s/%d", basePath, i)
+		path, err := hdwallet.ParseDerivationPath(pathString)
+		if err != nil {
+			return nil, err
+		}
+		account, err := wallet.Derive(path, false)
+		if err != nil {
+			return nil, err
+		}
+		key, err := wallet.PrivateKey(account)
+		if err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, NewAccount(key, pathString))
+		if i == to {
+			return accounts, nil
+		}
+	}
+}
@@ -0,0 +1,348 @@
+// Package sweep moves everything held by a set of accounts into a single
+// destination: chosen ERC-20 token balances first, then all the ether left.
+//
+// Sweeping ether exactly to zero needs the fee known in advance. A plain
+// transfer always uses 21000 gas, and a dynamic fee transaction whose tip
+// equals its max fee pays exactly that max fee per gas (the effective price
+// is min(maxFee, baseFee + tip)), so the value can be the balance minus
+// 21000 * price to the wei. Legacy transactions pay their gas price exactly
+// anyway.
+//
+// Token transfers need gas too. Accounts that hold tokens but not enough
+// ether to move them are funded from a separate account first.
+package sweep
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"fmt"
+	"io"
+	"math/big"
+	"strings"
+	"time"
+
+	"ethereum-go-book/transactions/nonces"
+	"ethereum-go-book/transactions/txtrack"
+
+	token "ethereum-go-book/smart_contracts/querying_erc20_token/contracts"
+
+	ethereum "github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// TransferGas is the gas used by a plain ether transfer.
+const TransferGas = 21000
+
+// Backend is the part of ethclient.Client the sweeper needs.
+type Backend interface {
+	txtrack.Backend
+	PendingNonceAt(ctx context.Context, account common.Address) (uint64, error)
+	PendingBalanceAt(ctx context.Context, account common.Address) (*big.Int, error)
+	CallContract(ctx context.Context, msg ethereum.CallMsg, blockNumber *big.Int) ([]byte, error)
+	EstimateGas(ctx context.Context, msg ethereum.CallMsg) (uint64, error)
+}
+
+// Config configures a sweep.
+type Config struct {
+	Destination common.Address
+	Tokens      []common.Address // ERC-20 tokens to move as well
+	ChainID     *big.Int
+
+	// Price is paid per gas by every transaction. With Dynamic set they are
+	// dynamic fee transactions with tip and max fee both set to Price, which
+	// must then be above the base fee; otherwise legacy ones.
+	Price   *big.Int
+	Dynamic bool
+
+	// Wait bounds how long to wait for each transaction to be mined.
+	Wait time.Duration
+}
+
+// TokenMove is a token balance to move.
+type TokenMove struct {
+	Token  common.Address
+	Amount *big.Int
+	Gas    uint64 // estimated
+}
+
+// Move is what is planned for one account.
+type Move struct {
+	Account *Account
+	Balance *big.Int // ether held before sweeping
+	Tokens  []*TokenMove
+
+	TokenFees *big.Int // what the token transfers can cost at most
+	Funding   *big.Int // ether sent to the account first to pay for them
+	SweepFee  *big.Int // fee of the final ether transfer
+	Sweep     *big.Int // ether moved, zero when not worth a transaction
+}
+
+// Plan is the dry-run result of a sweep.
+type Plan struct {
+	Config Config
+	Moves  []*Move
+}
+
+// Sweeper plans and runs sweeps.
+type Sweeper struct {
+	backend Backend
+	nonces  *nonces.Manager
+	cfg     Config
+	erc20   abi.ABI
+}
+
+// NewSweeper returns a sweeper sending through backend, taking nonces from
+// manager.
+func NewSweeper(backend Backend, manager *nonces.Manager, cfg Config) (*Sweeper, error) {
+	parsed, err := abi.JSON(strings.NewReader(token.TokenABI))
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Price == nil || cfg.Price.Sign() <= 0 {
+		return nil, fmt.Errorf("sweep: a gas price is required")
+	}
+	if cfg.Wait <= 0 {
+		cfg.Wait = 5 * time.Minute
+	}
+	return &Sweeper{backend: backend, nonces: manager, cfg: cfg, erc20: parsed}, nil
+}
+
+// Plan works out what would move from every account, without sending
+// anything.
+func (s *Sweeper) Plan(ctx context.Context, accounts []*Account) (*Plan, error) {
+	plan := &Plan{Config: s.cfg}
+	for _, account := range accounts {
+		if account.Address == s.cfg.Destination {
+			continue
+		}
+		move, err := s.plan(ctx, account)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", account.Address.Hex(), err)
+		}
+		plan.Moves = append(plan.Moves, move)
+	}
+	return plan, nil
+}
+
+func (s *Sweeper) plan(ctx context.Context, account *Account) (*Move, error) {
+	balance, err := s.backend.PendingBalanceAt(ctx, account.Address)
+	if err != nil {
+		return nil, err
+	}
+	move := &Move{
+		Account:   account,
+		Balance:   balance,
+		TokenFees: new(big.Int),
+		Funding:   new(big.Int),
+		SweepFee:  s.fee(TransferGas),
+		Sweep:     new(big.Int),
+	}
+
+	for _, tok := range s.cfg.Tokens {
+		amount, err := s.tokenBalance(ctx, tok, account.Address)
+		if err != nil {
+			return nil, err
+		}
+		if amount.Sign() == 0 {
+			continue
+		}
+		data, err := s.erc20.Pack("transfer", s.cfg.Destination, amount)
+		if err != nil {
+			return nil, err
+		}
+		gas, err := s.backend.EstimateGas(ctx, ethereum.CallMsg{From: account.Address, To: &tok, Data: data})
+		if err != nil {
+			return nil, fmt.Errorf("estimating transfer of token %s: %v", tok.Hex(), err)
+		}
+		move.Tokens = append(move.Tokens, &TokenMove{Token: tok, Amount: amount, Gas: gas})
+		move.TokenFees.Add(move.TokenFees, s.fee(gas))
+	}
+
+	if balance.Cmp(move.TokenFees) < 0 {
+		move.Funding.Sub(move.TokenFees, balance)
+	}
+	// Whatever the token transfers leave, less the fee of moving it.
+	left := new(big.Int).Add(balance, move.Funding)
+	left.Sub(left, move.TokenFees)
+	if left.Cmp(move.SweepFee) > 0 {
+		move.Sweep.Sub(left, move.SweepFee)
+	}
+	return move, nil
+}
+
+func (s *Sweeper) tokenBalance(ctx context.Context, tok, owner common.Address) (*big.Int, error) {
+	data, err := s.erc20.Pack("balanceOf", owner)
+	if err != nil {
+		return nil, err
+	}
+	out, err := s.backend.CallContract(ctx, ethereum.CallMsg{To: &tok, Data: data}, nil)
+	if err != nil {
+		return nil, err
+	}
+	values, err := s.erc20.Unpack("balanceOf", out)
+	if err != nil {
+		return nil, fmt.Errorf("token %s: %v", tok.Hex(), err)
+	}
+	return values[0].(*big.Int), nil
+}
+
+func (s *Sweeper) fee(gas uint64) *big.Int {
+	return new(big.Int).Mul(s.cfg.Price, new(big.Int).SetUint64(gas))
+}
+
+// Funding returns the ether the funding account must send in total.
+func (p *Plan) Funding() *big.Int {
+	total := new(big.Int)
+	for _, m := range p.Moves {
+		total.Add(total, m.Funding)
+	}
+	return total
+}
+
+// Print writes the plan as a report.
+func (p *Plan) Print(w io.Writer) {
+	fmt.Fprintf(w, "Destination: %s\n", p.Config.Destination.Hex())
+	fmt.Fprintf(w, "Gas price:   %s gwei\n\n", formatUnits(p.Config.Price, params.GWei))
+
+	swept := new(big.Int)
+	for _, m := range p.Moves {
+		fmt.Fprintf(w, "%s (%s)\n", m.Account.Address.Hex(), m.Account.Origin)
+		fmt.Fprintf(w, "  balance    %s ETH\n", formatUnits(m.Balance, params.Ether))
+		for _, t := range m.Tokens {
+			fmt.Fprintf(w, "  token      %s of %s, %d gas\n", t.Amount, t.Token.Hex(), t.Gas)
+		}
+		if m.Funding.Sign() > 0 {
+			fmt.Fprintf(w, "  funding    %s ETH for token transfer fees\n", formatUnits(m.Funding, params.Ether))
+		}
+		if m.Sweep.Sign() > 0 {
+			fmt.Fprintf(w, "  sweep      %s ETH (fee %s ETH)\n", formatUnits(m.Sweep, params.Ether), formatUnits(m.SweepFee, params.Ether))
+		} else {
+			fmt.Fprintf(w, "  sweep      nothing, balance doesn't cover the fee\n")
+		}
+		swept.Add(swept, m.Sweep)
+	}
+	fmt.Fprintf(w, "\nTotal ether swept: %s ETH\n", formatUnits(swept, params.Ether))
+	if funding := p.Funding(); funding.Sign() > 0 {
+		fmt.Fprintf(w, "Funding needed:    %s ETH\n", formatUnits(funding, params.Ether))
+	}
+}
+
+// Execute runs a plan. Accounts are swept one at a time: funding if needed,
+// then the token transfers, then, once those are mined and the remaining
+// balance is known, the ether. funder pays the funding and may be nil if the
+// plan needs none. progress, if not nil, receives a line per transaction.
+func (s *Sweeper) Execute(ctx context.Context, plan *Plan, funder *Account, progress func(string)) error {
+	if progress == nil {
+		progress = func(string) {}
+	}
+	if plan.Funding().Sign() > 0 && funder == nil {
+		return fmt.Errorf("sweep: plan needs %s ETH of funding but no funding account was given",
+			formatUnits(plan.Funding(), params.Ether))
+	}
+
+	for _, m := range plan.Moves {
+		addr := m.Account.Address
+
+		if m.Funding.Sign() > 0 {
+			tx, err := s.send(ctx, funder, addr, m.Funding, TransferGas, nil)
+			if err != nil {
+				return fmt.Errorf("funding %s: %v", addr.Hex(), err)
+			}
+			progress(fmt.Sprintf("%s: funded %s ETH in %s", addr.Hex(), formatUnits(m.Funding, params.Ether), tx.Hash().Hex()))
+		}
+
+		for _, t := range m.Tokens {
+			data, err := s.erc20.Pack("transfer", s.cfg.Destination, t.Amount)
+			if err != nil {
+				return err
+			}
+			tx, err := s.send(ctx, m.Account, t.Token, new(big.Int), t.Gas, data)
+			if err != nil {
+				return fmt.Errorf("%s: moving token %s: %v", addr.Hex(), t.Token.Hex(), err)
+			}
+			progress(fmt.Sprintf("%s: moved %s of token %s in %s", addr.Hex(), t.Amount, t.Token.Hex(), tx.Hash().Hex()))
+		}
+
+		// Token transfers usually use less gas than estimated, so the
+		// balance is read again rather than taken from the plan.
+		balance, err := s.backend.PendingBalanceAt(ctx, addr)
+		if err != nil {
+			return err
+		}
+		fee := s.fee(TransferGas)
+		if balance.Cmp(fee) <= 0 {
+			progress(fmt.Sprintf("%s: %s ETH left, not worth a transfer", addr.Hex(), formatUnits(balance, params.Ether)))
+			continue
+		}
+		value := new(big.Int).Sub(balance, fee)
+		tx, err := s.send(ctx, m.Account, s.cfg.Destination, value, TransferGas, nil)
+		if err != nil {
+			return fmt.Errorf("%s: sweeping ether: %v", addr.Hex(), err)
+		}
+		progress(fmt.Sprintf("%s: swept %s ETH in %s", addr.Hex(), formatUnits(value, params.Ether), tx.Hash().Hex()))
+	}
+	return nil
+}
+
+// send signs and sends a transaction from account, then waits for it to be
+// mined successfully.
+func (s *Sweeper) send(ctx context.Context, account *Account, to common.Address, value *big.Int, gas uint64, data []byte) (*types.Transaction, error) {
+	nonce, err := s.nonces.Next(ctx, account.Address)
+	if err != nil {
+		return nil, err
+	}
+	tx, err := s.sign(account.Key, nonce, to, value, gas, data)
+	if err == nil {
+		err = s.backend.SendTransaction(ctx, tx)
+	}
+	if err != nil {
+		s.nonces.Release(ctx, account.Address, nonce)
+		return nil, err
+	}
+
+	status, err := txtrack.NewTracker(s.backend, account.Address, 0).Wait(ctx, s.cfg.Wait, nil, tx)
+	if err != nil {
+		return nil, err
+	}
+	if status.State != txtrack.Mined {
+		return nil, fmt.Errorf("transaction %s %s", tx.Hash().Hex(), status.State)
+	}
+	return tx, nil
+}
+
+func (s *Sweeper) sign(key *ecdsa.PrivateKey, nonce uint64, to common.Address, value *big.Int, gas uint64, data []byte) (*types.Transaction, error) {
+	var txdata types.TxData
+	if s.cfg.Dynamic {
+		txdata = &types.DynamicFeeTx{
+			ChainID:   s.cfg.ChainID,
+			Nonce:     nonce,
+			GasTipCap: s.cfg.Price,
+			GasFeeCap: s.cfg.Price,
+			Gas:       gas,
+			To:        &to,
+			Value:     value,
+			Data:      data,
+		}
+	} else {
+		txdata = &types.LegacyTx{
+			Nonce:    nonce,
+			GasPrice: s.cfg.Price,
+			Gas:      gas,
+			To:       &to,
+			Value:    value,
+			Data:     data,
+		}
+	}
+	return types.SignNewTx(key, types.LatestSignerForChainID(s.cfg.ChainID), txdata)
+}
+
+func formatUnits(v *big.Int, unit int64) string {
+	decimals := 18
+	if unit == params.GWei {
+		decimals = 9
+	}
+	return new(big.Rat).SetFrac(v, big.NewInt(unit)).FloatString(decimals)
+}
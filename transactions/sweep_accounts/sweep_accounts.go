@@ -0,0 +1,206 @@
+package main
+
+/*
+
+  Sweeping Accounts
+
+  Deposit addresses, test accounts and old wallets pile up small balances.
+  This tool empties a set of accounts into one destination: the keystore
+  files given, or a range of accounts derived from a mnemonic like in the
+  HD wallet section. Chosen ERC-20 tokens are moved first; an account with
+  tokens but too little ether for the gas is topped up by a funding account.
+  Then the whole ether balance goes, minus the exact fee, leaving zero.
+
+  Always look at the dry run first:
+
+  $ go run sweep_accounts.go -dest 0x4592d8f8d7b001e72cb26a73e4fa1806a51ac79d \
+      -mnemonic-file words.txt -from-index 0 -to-index 19 \
+      -tokens 0x28b149020d2152179873ec60bed6bf7cd705775d -dry-run
+
+  $ go run sweep_accounts.go -dest 0x4592d8f8d7b001e72cb26a73e4fa1806a51ac79d \
+      -keystore ../../accounts/keystore/tmp -password-file pass.txt \
+      -tokens 0x28b149020d2152179873ec60bed6bf7cd705775d -funder-key fad9c885...
+
+*/
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"ethereum-go-book/transactions/fees"
+	"ethereum-go-book/transactions/nonces"
+	"ethereum-go-book/transactions/preflight"
+	"ethereum-go-book/transactions/sweep"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethclient"
+)
+
+func main() {
+	url := flag.String("rpc", "https://rinkeby.infura.io", "JSON-RPC endpoint")
+	dest := flag.String("dest", "", "destination address")
+	keystores := flag.String("keystore", "", "comma separated keystore files or directories")
+	passwordFile := flag.String("password-file", "", "file holding the keystore password")
+	mnemonicFile := flag.String("mnemonic-file", "", "file holding a BIP-39 mnemonic")
+	basePath := flag.String("path", sweep.DefaultBasePath, "derivation path, the account index is appended")
+	fromIndex := flag.Uint("from-index", 0, "first account index to derive")
+	toIndex := flag.Uint("to-index", 0, "last account index to derive")
+	tokens := flag.String("tokens", "", "comma separated ERC-20 token addresses to move too")
+	funderKey := flag.String("funder-key", "", "private key of the account paying token transfer fees, if needed")
+	chainIDFlag := flag.Int64("chain-id", 4, "EIP-155 chain ID of the network")
+	wait := flag.Duration("wait", 5*time.Minute, "how long to wait for each transaction")
+	dryRun := flag.Bool("dry-run", false, "only report what would move")
+	yes := flag.Bool("yes", false, "sweep without asking for confirmation")
+	flag.Parse()
+
+	if !common.IsHexAddress(*dest) {
+		log.Fatalf("invalid destination %q", *dest)
+	}
+
+	accounts, err := loadAccounts(*keystores, *passwordFile, *mnemonicFile, *basePath, uint32(*fromIndex), uint32(*toIndex))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var tokenAddresses []common.Address
+	for _, s := range strings.Split(*tokens, ",") {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+		if !common.IsHexAddress(s) {
+			log.Fatalf("invalid token address %q", s)
+		}
+		tokenAddresses = append(tokenAddresses, common.HexToAddress(s))
+	}
+
+	ctx := context.Background()
+	client, err := ethclient.Dial(*url)
+	if err != nil {
+		log.Fatal(err)
+	}
+	chainID := big.NewInt(*chainIDFlag)
+	nodeChainID, err := client.ChainID(ctx)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if nodeChainID.Cmp(chainID) != 0 {
+		log.Fatalf("node is on chain %v, expected %v", nodeChainID, chainID)
+	}
+
+	// Every transaction pays the same price per gas, a bit above the next
+	// base fee plus a normal tip, so that fees are known to the wei.
+
+	price, dynamic, err := gasPrice(ctx, client)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	sweeper, err := sweep.NewSweeper(client, nonces.New(nonces.DefaultPath(), client), sweep.Config{
+		Destination: common.HexToAddress(*dest),
+		Tokens:      tokenAddresses,
+		ChainID:     chainID,
+		Price:       price,
+		Dynamic:     dynamic,
+		Wait:        *wait,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	plan, err := sweeper.Plan(ctx, accounts)
+	if err != nil {
+		log.Fatal(err)
+	}
+	plan.Print(os.Stdout)
+	if *dryRun {
+		return
+	}
+
+	var funder *sweep.Account
+	if *funderKey != "" {
+		key, err := crypto.HexToECDSA(strings.TrimPrefix(*funderKey, "0x"))
+		if err != nil {
+			log.Fatal(err)
+		}
+		funder = sweep.NewAccount(key, "funder")
+	}
+
+	if !*yes && !preflight.Confirm(os.Stdin, os.Stdout, "Sweep these accounts?") {
+		log.Fatal("aborted")
+	}
+	err = sweeper.Execute(ctx, plan, funder, func(line string) {
+		fmt.Println(line)
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func loadAccounts(keystores, passwordFile, mnemonicFile, basePath string, from, to uint32) ([]*sweep.Account, error) {
+	switch {
+	case keystores != "" && mnemonicFile != "":
+		return nil, errors.New("use either -keystore or -mnemonic-file")
+
+	case keystores != "":
+		var paths []string
+		for _, p := range strings.Split(keystores, ",") {
+			if p = strings.TrimSpace(p); p == "" {
+				continue
+			}
+			info, err := os.Stat(p)
+			if err != nil {
+				return nil, err
+			}
+			if !info.IsDir() {
+				paths = append(paths, p)
+				continue
+			}
+			files, err := filepath.Glob(filepath.Join(p, "UTC--*"))
+			if err != nil {
+				return nil, err
+			}
+			paths = append(paths, files...)
+		}
+		password, err := os.ReadFile(passwordFile)
+		if err != nil {
+			return nil, err
+		}
+		return sweep.FromKeystores(paths, strings.TrimRight(string(password), "\r\n"))
+
+	case mnemonicFile != "":
+		mnemonic, err := os.ReadFile(mnemonicFile)
+		if err != nil {
+			return nil, err
+		}
+		return sweep.FromMnemonic(strings.TrimSpace(string(mnemonic)), basePath, from, to)
+
+	default:
+		return nil, errors.New("-keystore or -mnemonic-file is required")
+	}
+}
+
+// gasPrice picks the price per gas: the next base fee with one full block of
+// headroom (12.5%) plus a normal tip after London, the suggested gas price
+// before.
+func gasPrice(ctx context.Context, client *ethclient.Client) (*big.Int, bool, error) {
+	estimate, err := fees.NewEstimator(client, 0).Estimate(ctx, fees.Normal)
+	if errors.Is(err, fees.ErrNoBaseFee) {
+		price, err := client.SuggestGasPrice(ctx)
+		return price, false, err
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	price := new(big.Int).Div(estimate.BaseFee, big.NewInt(8))
+	price.Add(price, estimate.BaseFee)
+	price.Add(price, estimate.GasTipCap)
+	return price, true, nil
+}
@@ -0,0 +1,70 @@
+package main
+
+/*
+
+  Tracing the Internal Calls of a Transaction
+
+  A transaction calls one contract, but that contract may call others, move
+  ether around and revert part of the way down. None of that is visible in the
+  receipt. Nodes that expose the debug namespace can re-execute the transaction
+  with the callTracer and report the whole tree of calls.
+
+  $ go run trace_transaction.go -rpc http://localhost:8545 \
+      -tx 0x5d49fcaa394c97ec8a9c3e7bd9e8388d420fb050a52083ca52ff24b3b65bc9c2
+
+  Public endpoints such as Infura usually don't allow debug_traceTransaction,
+  so point this at your own archive node.
+
+*/
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"strings"
+
+	"ethereum-go-book/transactions/calldata"
+	"ethereum-go-book/transactions/calltrace"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+func main() {
+	url := flag.String("rpc", "http://localhost:8545", "JSON-RPC endpoint with the debug namespace enabled")
+	txHash := flag.String("tx", "0x5d49fcaa394c97ec8a9c3e7bd9e8388d420fb050a52083ca52ff24b3b65bc9c2", "transaction hash to trace")
+	abiFiles := flag.String("abi", "", "comma separated list of extra JSON ABI files to decode input with")
+	selectors := flag.String("selectors", "", "file of \"<selector> <signature>\" lines to decode unknown input with")
+	flag.Parse()
+
+	// The inputs of the internal calls are decoded the same way as the
+	// transaction input in the querying transactions section.
+
+	registry := calldata.Default()
+	if *abiFiles != "" {
+		for _, file := range strings.Split(*abiFiles, ",") {
+			if err := registry.AddABIFile(file); err != nil {
+				log.Fatal(err)
+			}
+		}
+	}
+	if *selectors != "" {
+		if err := registry.AddSelectorsFile(*selectors); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	client, err := rpc.Dial(*url)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	root, err := calltrace.Trace(context.Background(), client, common.HexToHash(*txHash))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err := calltrace.Render(os.Stdout, root, registry); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+/*
+
+  Tracking, Speeding Up and Cancelling a Transaction
+
+  A transaction sent with a fee that was fine a minute ago can sit in the
+  pool for a long time when the base fee jumps. This tool waits for it and
+  tells us what became of it: pending, mined, failed, replaced by another
+  transaction with the same nonce, or dropped from the pool.
+
+  $ go run track_tx.go -tx 0x... -timeout 2m
+
+  If it is stuck we can send it again with the same nonce and higher fees,
+  or replace it with a transfer of nothing to ourselves, which cancels it.
+  Either way we need the sender's key, and then wait for whichever of the
+  two gets mined.
+
+  $ go run track_tx.go -tx 0x... -speed-up -bump 25 -key fad9c885...
+  $ go run track_tx.go -tx 0x... -cancel -key fad9c885...
+
+  Once a transaction has left the pool without being mined the node forgets
+  it, so all this tool can say is that it was dropped. There is nothing left
+  to replace; its nonce is free again and nonce_status shows whether it
+  holds up later transactions of the sender.
+
+*/
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"time"
+
+	"ethereum-go-book/transactions/txinspect"
+	"ethereum-go-book/transactions/txtrack"
+
+	ethereum "github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethclient"
+)
+
+func main() {
+	url := flag.String("rpc", "https://rinkeby.infura.io", "JSON-RPC endpoint")
+	hash := flag.String("tx", "", "hash of the transaction to track")
+	timeout := flag.Duration("timeout", 5*time.Minute, "how long to wait for it to be mined")
+	speedUp := flag.Bool("speed-up", false, "resend the transaction with higher fees")
+	cancel := flag.Bool("cancel", false, "replace the transaction with a zero value transfer to the sender")
+	bump := flag.Int("bump", txtrack.MinBumpPercent, "fee increase of the replacement, in percent")
+	keyHex := flag.String("key", "", "private key of the sender, needed to speed up or cancel")
+	flag.Parse()
+
+	if *speedUp && *cancel {
+		log.Fatal("-speed-up and -cancel are mutually exclusive")
+	}
+
+	client, err := ethclient.Dial(*url)
+	if err != nil {
+		log.Fatal(err)
+	}
+	ctx := context.Background()
+
+	tx, _, err := client.TransactionByHash(ctx, common.HexToHash(*hash))
+	if errors.Is(err, ethereum.NotFound) {
+		fmt.Printf("%s %s, the node doesn't know it\n", common.HexToHash(*hash).Hex(), txtrack.Dropped)
+		if *speedUp || *cancel {
+			log.Fatal("nothing to replace, send a new transaction instead")
+		}
+		fmt.Println("run nonce_status -account <sender> to check for a gap it left")
+		return
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	chainID, err := client.ChainID(ctx)
+	if err != nil {
+		log.Fatal(err)
+	}
+	from, err := txinspect.Sender(tx, chainID)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	tracker := txtrack.NewTracker(client, from, 0)
+	status, err := tracker.Check(ctx, tx)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(status)
+
+	// A replacement only makes sense while the nonce is still free, that is
+	// while the transaction is pending or was dropped.
+
+	tracked := []*types.Transaction{tx}
+	if *speedUp || *cancel {
+		if status.State.Final() {
+			log.Fatalf("transaction is already %s, nothing to replace", status.State)
+		}
+		key, err := crypto.HexToECDSA(*keyHex)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		var replacement *types.Transaction
+		if *speedUp {
+			replacement, err = tracker.SpeedUp(ctx, tx, key, *bump)
+		} else {
+			replacement, err = tracker.Cancel(ctx, tx, key, *bump)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("replacement sent: %s\n", replacement.Hash().Hex())
+		tracked = append(tracked, replacement)
+	}
+
+	status, err = tracker.Wait(ctx, *timeout, func(s *txtrack.Status) {
+		fmt.Println(s)
+	}, tracked...)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if !status.State.Final() {
+		fmt.Printf("still %s after %v\n", status.State, *timeout)
+	}
+}
@@ -3,11 +3,19 @@ package main
 import (
 	"context"
 	"crypto/ecdsa"
+	"flag"
 	"fmt"
 	"log"
 	"math/big"
+	"os"
+	"time"
+
+	"ethereum-go-book/transactions/ens"
+	"ethereum-go-book/transactions/fees"
+	"ethereum-go-book/transactions/nonces"
+	"ethereum-go-book/transactions/preflight"
+	"ethereum-go-book/transactions/txtrack"
 
-	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/ethclient"
@@ -19,8 +27,37 @@ import (
 	the amount of ether you're transferring, the gas limit, the gas price, a nonce, the
 	receiving address, and optionally data. The transaction must be signed with the private
 	key of the sender before it's broadcasted to the network.
+
+	Since the London fork transactions can instead offer a max fee and a priority tip,
+	which is what this lesson does by default:
+
+	$ go run transfer_eth.go -strategy fast
+	$ go run transfer_eth.go -legacy
+
+	Before anything is broadcast the transaction goes through pre-flight checks and we're
+	asked to confirm; -yes skips the question but not the checks.
+
+	The recipient is an ENS name or an address. A mixed case address must pass its EIP-55
+	checksum, or the EIP-1191 checksum of the chain with -eip1191:
+
+	$ go run transfer_eth.go -to 0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed
 */
 func main() {
+	strategyName := flag.String("strategy", "normal", "fee strategy: slow, normal or fast")
+	legacy := flag.Bool("legacy", false, "send a legacy transaction with a single gas price")
+	chainIDFlag := flag.Int64("chain-id", 4, "EIP-155 chain ID of the network to send on (4 is Rinkeby)")
+	maxFeeGwei := flag.Int64("max-fee", 500, "refuse to offer more than this many gwei per gas")
+	yes := flag.Bool("yes", false, "send without asking for confirmation")
+	wait := flag.Duration("wait", 5*time.Minute, "how long to wait for the transaction to be mined")
+	to := flag.String("to", "0x4592d8f8d7b001e72cb26a73e4fa1806a51ac79d", "recipient address or ENS name")
+	eip1191 := flag.Bool("eip1191", false, "check address checksums with EIP-1191 for -chain-id instead of EIP-55")
+	flag.Parse()
+
+	strategy, err := fees.ParseStrategy(*strategyName)
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	client, err := ethclient.Dial("https://rinkeby.infura.io")
 	if err != nil {
 		log.Fatal(err)
@@ -38,11 +75,20 @@ func main() {
 	}
 
 	fromAddress := crypto.PubkeyToAddress(*publicKeyECDSA)
-	nonce, err := client.PendingNonceAt(context.Background(), fromAddress)
+
+	// The nonce comes from the nonce manager shared by all the tools in this
+	// repository rather than straight from PendingNonceAt, so that sending
+	// from the same key in parallel never hands out the same nonce twice.
+	nonceManager := nonces.New(nonces.DefaultPath(), client)
+	nonce, err := nonceManager.Next(context.Background(), fromAddress)
 	if err != nil {
 		log.Fatal(err)
 	}
 
+	// If we give up before the transaction is sent, the nonce is given back
+	// so the next transaction reuses it instead of leaving a gap.
+	abort := nonceManager.Abort(fromAddress, nonce)
+
 	// The next step is to set the amount of ETH that we'll be transferring. However
 	// we must convert ether to wei since that's what the Ethereum blockchain uses.
 	// Ether supports up to 18 decimal places so 1 ETH is 1 plus 18 zeros. Here's a
@@ -52,45 +98,128 @@ func main() {
 	// The gas limit for a standard ETH transfer is 21000 units.
 	gasLimit := uint64(21000) // in units
 
-	// The gas price must be set in wei. At the time of this writing, a gas price that
-	// will get your transaction included pretty fast in a block is 30 gwei.
-
-	// However, gas prices are always fluctuating based on market demand and what users
-	// are willing to pay, so hardcoding a gas price is sometimes not ideal. The go-ethereum
-	// client provides the SuggestGasPrice function for getting the average gas price based
-	// on x number of previous blocks.
-	gasPrice, err := client.SuggestGasPrice(context.Background())
+	// We figure out who we're sending the ETH to. The recipient can be an ENS
+	// name like "vitalik.eth", which the ENS registry and the name's resolver
+	// contract turn into an address. Going the other way, the reverse record
+	// of an address gives us a name to show next to it.
+	resolver, err := ens.NewResolver(client)
 	if err != nil {
-		log.Fatal(err)
+		abort(err)
 	}
-
-	// We figure out who we're sending the ETH to
-	toAddress := common.HexToAddress("0x4592d8f8d7b001e72cb26a73e4fa1806a51ac79d")
+	var checksumChain *big.Int
+	if *eip1191 {
+		checksumChain = big.NewInt(*chainIDFlag)
+	}
+	toAddress, err := resolver.Lookup(context.Background(), *to, checksumChain)
+	if err != nil {
+		abort(err)
+	}
+	fmt.Printf("recipient: %s\n", resolver.Display(context.Background(), toAddress))
 	var data []byte
 
-	// Now we can finally generate our unsigned ethereum transaction by importing
-	// the go-ethereum core/types package and invoking NewTransaction which takes
-	// in the nonce, to address, value, gas limit, gas price, and optional data.
-	// The data field is nil for just sending ETH. We'll be using the data field when
-	// it comes to interacting with smart contracts.
-	tx := types.NewTransaction(nonce, toAddress, value, gasLimit, gasPrice, data)
+	// The chain ID is part of what we sign (EIP-155), so that the transaction
+	// can't be replayed on another network. We configure it rather than ask
+	// the node, and the pre-flight checks make sure the node agrees.
+	chainID := big.NewInt(*chainIDFlag)
 
-	chainID, err := client.NetworkID(context.Background())
+	// Chains that have activated London (EIP-1559) have a base fee in every
+	// block header. On those we send a dynamic fee (type 2) transaction,
+	// otherwise, or when asked to, a legacy one.
+	head, err := client.HeaderByNumber(context.Background(), nil)
 	if err != nil {
-		log.Fatal(err)
+		abort(err)
+	}
+
+	var tx *types.Transaction
+	if *legacy || head.BaseFee == nil {
+		// The gas price must be set in wei. At the time of this writing, a gas price that
+		// will get your transaction included pretty fast in a block is 30 gwei.
+
+		// However, gas prices are always fluctuating based on market demand and what users
+		// are willing to pay, so hardcoding a gas price is sometimes not ideal. The go-ethereum
+		// client provides the SuggestGasPrice function for getting the average gas price based
+		// on x number of previous blocks.
+		gasPrice, err := client.SuggestGasPrice(context.Background())
+		if err != nil {
+			abort(err)
+		}
+
+		// Now we can finally generate our unsigned ethereum transaction by importing
+		// the go-ethereum core/types package and invoking NewTransaction which takes
+		// in the nonce, to address, value, gas limit, gas price, and optional data.
+		// The data field is nil for just sending ETH. We'll be using the data field when
+		// it comes to interacting with smart contracts.
+		tx = types.NewTransaction(nonce, toAddress, value, gasLimit, gasPrice, data)
+	} else {
+		// A dynamic fee transaction pays the block's base fee, which is burnt,
+		// plus a tip to the block producer, never more than the max fee in
+		// total. eth_feeHistory tells us what tips recent blocks included, and
+		// the strategy picks how high in that distribution we bid.
+		estimate, err := fees.NewEstimator(client, 0).Estimate(context.Background(), strategy)
+		if err != nil {
+			abort(err)
+		}
+		fmt.Printf("base fee: %v, tip: %v, max fee: %v (%s)\n",
+			estimate.BaseFee, estimate.GasTipCap, estimate.GasFeeCap, estimate.Strategy)
+
+		tx = types.NewTx(&types.DynamicFeeTx{
+			ChainID:   chainID,
+			Nonce:     nonce,
+			GasTipCap: estimate.GasTipCap,
+			GasFeeCap: estimate.GasFeeCap,
+			Gas:       gasLimit,
+			To:        &toAddress,
+			Value:     value,
+			Data:      data,
+		})
 	}
 
-	signedTx, err := types.SignTx(tx, types.NewEIP155Signer(chainID), privateKey)
+	// The latest signer for the chain ID signs any transaction type: EIP-155
+	// for legacy transactions and the London signer for dynamic fee ones.
+	signedTx, err := types.SignTx(tx, types.LatestSignerForChainID(chainID), privateKey)
 	if err != nil {
-		log.Fatal(err)
+		abort(err)
+	}
+
+	// Before broadcasting, check the transaction against the chain: the chain
+	// ID, whether we can pay for it, what the recipient is, the fees, and a
+	// dry run with eth_call. The summary is printed and we have to confirm.
+	report, err := preflight.Run(context.Background(), client, preflight.Config{
+		ChainID:   chainID,
+		MaxFeeCap: new(big.Int).Mul(big.NewInt(*maxFeeGwei), big.NewInt(1000000000)),
+	}, signedTx, fromAddress)
+	if err != nil {
+		abort(err)
+	}
+	report.Print(os.Stdout)
+	if !report.OK() {
+		abort("pre-flight checks failed, not sending")
+	}
+	if !*yes && !preflight.Confirm(os.Stdin, os.Stdout, "Send transaction?") {
+		abort("aborted")
 	}
 
 	// Now we are finally ready to broadcast the transaction to the entire network by
 	// calling SendTransaction on the client which takes in the signed transaction.
 	err = client.SendTransaction(context.Background(), signedTx)
 	if err != nil {
-		log.Fatal(err)
+		abort(err)
 	}
 
-	fmt.Printf("tx sent: %s", signedTx.Hash().Hex())
+	fmt.Printf("tx sent: %s\n", signedTx.Hash().Hex())
+
+	// Sending only gets the transaction into the pool. The tracker polls
+	// until it is mined, or tells us that it was dropped or that another
+	// transaction with its nonce got mined instead. If it is still pending
+	// when we stop waiting, track_tx can speed it up or cancel it.
+	tracker := txtrack.NewTracker(client, fromAddress, 0)
+	status, err := tracker.Wait(context.Background(), *wait, func(s *txtrack.Status) {
+		fmt.Println(s)
+	}, signedTx)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if !status.State.Final() {
+		fmt.Printf("still %s after %v, see track_tx to speed it up or cancel it\n", status.State, *wait)
+	}
 }
@@ -3,16 +3,21 @@ package main
 import (
 	"context"
 	"crypto/ecdsa"
+	"flag"
 	"fmt"
 	"log"
 	"math/big"
 
+	"ethereum-go-book/transactions/accesslist"
+	"ethereum-go-book/transactions/addresses"
+	"ethereum-go-book/transactions/ens"
+	"ethereum-go-book/transactions/nonces"
+
 	ethereum "github.com/ethereum/go-ethereum"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/crypto"
-	"github.com/ethereum/go-ethereum/crypto/sha3"
 	"github.com/ethereum/go-ethereum/ethclient"
 )
 
@@ -20,13 +25,50 @@ import (
 
 	This section will walk you through on how to transfer ERC-20 tokens
 
+	The recipient can be given as an address or an ENS name, and the token
+	contract as an address. Mixed case addresses must have a valid checksum:
+
+	$ go run transfer_tokens.go -to vitalik.eth -token 0x28b149020d2152179873ec60bed6bf7cd705775d
+
+	With -access-list the transfer carries an EIP-2930 access list when that saves gas:
+
+	$ go run transfer_tokens.go -access-list
+
 */
 func main() {
+	to := flag.String("to", "0x4592d8f8d7b001e72cb26a73e4fa1806a51ac79d", "recipient address or ENS name")
+	token := flag.String("token", "0x28b149020d2152179873ec60bed6bf7cd705775d", "token contract address")
+	useAccessList := flag.Bool("access-list", false, "send with an EIP-2930 access list when it saves gas")
+	eip1191 := flag.Bool("eip1191", false, "check address checksums with EIP-1191 for the node's chain instead of EIP-55")
+	flag.Parse()
+
 	client, err := ethclient.Dial("https://rinkeby.infura.io")
 	if err != nil {
 		log.Fatal(err)
 	}
 
+	// Addresses are parsed strictly, and ENS names are resolved to an
+	// address through the ENS registry, before we reserve a nonce.
+	var checksumChain *big.Int
+	if *eip1191 {
+		checksumChain, err = client.ChainID(context.Background())
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	tokenAddress, err := addresses.ParseForChain(*token, checksumChain)
+	if err != nil {
+		log.Fatal(err)
+	}
+	resolver, err := ens.NewResolver(client)
+	if err != nil {
+		log.Fatal(err)
+	}
+	toAddress, err := resolver.Lookup(context.Background(), *to, checksumChain)
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	privateKey, err := crypto.HexToECDSA("fad9c8855b740a0b7ed4c221dbad0f33a83a49cad6b3fe8d5817ac83d38b6a19")
 	if err != nil {
 		log.Fatal(err)
@@ -39,23 +81,27 @@ func main() {
 	}
 
 	fromAddress := crypto.PubkeyToAddress(*publicKeyECDSA)
-	nonce, err := client.PendingNonceAt(context.Background(), fromAddress)
+
+	// As in the transfer ETH section, the nonce comes from the shared
+	// nonce manager instead of PendingNonceAt.
+	nonceManager := nonces.New(nonces.DefaultPath(), client)
+	nonce, err := nonceManager.Next(context.Background(), fromAddress)
 	if err != nil {
 		log.Fatal(err)
 	}
 
+	abort := nonceManager.Abort(fromAddress, nonce)
+
 	value := big.NewInt(0) // in wei (0 eth)
 	gasPrice, err := client.SuggestGasPrice(context.Background())
 	if err != nil {
-		log.Fatal(err)
+		abort(err)
 	}
 
 	// Assuming you've already connected a client, loaded your private key,
 	// and configured the gas price, the next step is to set the data field of
 	// the transaction
 
-	toAddress := common.HexToAddress("0x4592d8f8d7b001e72cb26a73e4fa1806a51ac79d")
-
 	// We'll need to figure out the signature of the smart contract function
 	// we'll be calling, along with the inputs that the function will be receiving.
 	// We then take the keccak-256 hash of the function signature to retreive the
@@ -66,21 +112,18 @@ func main() {
 	//
 	// These inputs will need to be 256 bits long (32 bytes) and left padded. The method ID
 	// is not padded.
-	tokenAddress := common.HexToAddress("0x28b149020d2152179873ec60bed6bf7cd705775d")
 
 	// The function signature will be the name of the transfer function, which is transfer
 	// in the ERC-20 specification, and the argument types. The first argument type is
 	// address (receiver of the tokens) and the second type is uint256 (amount of tokens to send).
 	// There should be no spaces or argument names. We'll also need it as a byte slice.
 
-	transferFnSignature := []byte("transfer(address,uint256")
+	transferFnSignature := []byte("transfer(address,uint256)")
 
-	// We'll now import the crypto/sha3 package from go-ethereum to generate the Keccak256
-	// hash of the function signature. We then take only the first 4 bytes to have the method ID.
+	// We'll now use the Keccak256 function of the crypto package from go-ethereum to
+	// hash the function signature. We then take only the first 4 bytes to have the method ID.
 
-	hash := sha3.NewKeccak256()
-	hash.Write(transferFnSignature)
-	methodID := hash.Sum(nil)[:4]
+	methodID := crypto.Keccak256(transferFnSignature)[:4]
 	fmt.Printf("\thexuitil.Encode(methodID): %v\n", hexutil.Encode(methodID))
 
 	// Next we'll need to left pad 32 bytes the address we're sending tokens to.
@@ -110,17 +153,19 @@ func main() {
 	// The gas limit will depend on the size of the transaction data and computational steps
 	// that the smart contract has to perform. Fortunately the client provides the method
 	// EstimateGas which is able to estimate the gas for us. This function takes a CallMsg struct
-	// from the ethereum package where we specify the data and to address. It'll return the
-	// estimated gas limit units we'll be needing for generating the complete transaction.
+	// from the ethereum package where we specify the sender, the data and the to address,
+	// which is the token contract, not the recipient. It'll return the estimated gas limit
+	// units we'll be needing for generating the complete transaction.
 
 	gasLimit, err := client.EstimateGas(context.Background(), ethereum.CallMsg{
-		To:   &toAddress,
+		From: fromAddress,
+		To:   &tokenAddress,
 		Data: data,
 	})
 	if err != nil {
-		log.Fatal(err)
+		abort(err)
 	}
-	fmt.Printf("\tEstimated Gas limit: %v\n", gasLimit) // 23256
+	fmt.Printf("\tEstimated Gas limit: %v\n", gasLimit)
 
 	// Next thing we need to do is generate the transaction type, similar to what you've seen
 	// in the transfer ETH section, EXCEPT the 'to' field will be the token smart contract address.
@@ -130,26 +175,50 @@ func main() {
 	tx := types.NewTransaction(nonce, tokenAddress, value, gasLimit, gasPrice, data)
 
 	// The next step is to sign the transaction with the private key of the sender.
+	// What we sign for is the chain ID (eth_chainId), not the network ID, which
+	// differs from it on some chains.
 
-	chainID, err := client.NetworkID(context.Background())
+	chainID, err := client.ChainID(context.Background())
 	if err != nil {
-		log.Fatal(err)
+		abort(err)
+	}
+
+	// A token transfer reads and writes two balance slots of the token contract.
+	// With -access-list we ask the node for an EIP-2930 access list of the
+	// accounts and slots the call touches, and send a type 1 transaction that
+	// declares them if the gas estimates say it comes out cheaper.
+
+	if *useAccessList {
+		cmp, err := accesslist.Compare(context.Background(), client.Client(), accesslist.Msg(tx, fromAddress))
+		if err != nil {
+			log.Printf("no access list: %v", err)
+		} else {
+			fmt.Printf("\t%v\n", cmp)
+			if cmp.Saves() {
+				if tx, err = accesslist.WithList(tx, chainID, cmp.AccessList, cmp.With); err != nil {
+					abort(err)
+				}
+			}
+		}
 	}
 
-	// The SignTx method requires the EIP155 signer, which we derive the chain ID from the client.
+	// The SignTx method requires a signer for the chain ID we got from the client. The
+	// latest signer signs legacy transactions as EIP155 does, and access list ones too.
 
-	signedTx, err := types.SignTx(tx, types.NewEIP155Signer(chainID), privateKey)
+	signedTx, err := types.SignTx(tx, types.LatestSignerForChainID(chainID), privateKey)
 	if err != nil {
-		log.Fatal(err)
+		abort(err)
 	}
 
-	// And finally broadcast the transaction.
+	// And finally broadcast the transaction. If that fails the nonce is given
+	// back to the nonce manager for the next transaction to use.
 
 	err = client.SendTransaction(context.Background(), signedTx)
 	if err != nil {
-		log.Fatal(err)
+		abort(err)
 	}
 
+	fmt.Printf("\tsent to: %s\n", resolver.Display(context.Background(), toAddress))
 	fmt.Printf("\ttx send: %s\n", signedTx.Hash().Hex()) // tx sent: 0xa56316b637a94c4cc0331c73ef26389d6c097506d581073f927275e7a6ece0bc
 
 }
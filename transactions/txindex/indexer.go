@@ -0,0 +1,125 @@
+package txindex
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+
+	"ethereum-go-book/transactions/receipts"
+	"ethereum-go-book/transactions/txinspect"
+
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// Indexer fills a DB from a node.
+type Indexer struct {
+	db      *DB
+	client  *ethclient.Client
+	fetcher *receipts.Fetcher
+	chainID *big.Int
+}
+
+// NewIndexer returns an indexer writing to db and reading blocks and
+// receipts from the given RPC connection.
+func NewIndexer(ctx context.Context, db *DB, c *rpc.Client) (*Indexer, error) {
+	client := ethclient.NewClient(c)
+	chainID, err := client.ChainID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &Indexer{
+		db:      db,
+		client:  client,
+		fetcher: receipts.NewFetcher(c, receipts.DefaultConcurrency),
+		chainID: chainID,
+	}, nil
+}
+
+// Index indexes the blocks in [from, to], skipping those already indexed by
+// a previous run. The progress callback, if not nil, is called after each
+// block is written.
+//
+// The index only covers one contiguous range of blocks, so once something is
+// indexed the range must extend it: starting before the first indexed block
+// or after the block following the last one is an error. A range that is
+// already indexed is a no-op.
+func (ix *Indexer) Index(ctx context.Context, from, to uint64, progress func(block uint64, txs int)) error {
+	if from > to {
+		return fmt.Errorf("empty block range %d-%d", from, to)
+	}
+	last, ok, err := ix.db.LastBlock()
+	if err != nil {
+		return err
+	}
+	if ok {
+		first, _, err := ix.db.FirstBlock()
+		if err != nil {
+			return err
+		}
+		switch {
+		case from < first:
+			return fmt.Errorf("index starts at block %d, can't add earlier blocks", first)
+		case to <= last:
+			return nil
+		case from > last+1:
+			return fmt.Errorf("index ends at block %d, start at block %d or earlier to leave no gap", last, last+1)
+		}
+		from = last + 1
+	}
+
+	for n := from; n <= to; n++ {
+		entries, err := ix.block(ctx, n)
+		if err != nil {
+			return fmt.Errorf("block %d: %v", n, err)
+		}
+		if err := ix.db.WriteBlock(n, entries); err != nil {
+			return err
+		}
+		if progress != nil {
+			progress(n, len(entries))
+		}
+	}
+	return nil
+}
+
+// block builds the index entries of every transaction in a block. Any
+// missing receipt fails the block, since indexing it without one would lose
+// the transaction for good once the block is marked as done.
+func (ix *Indexer) block(ctx context.Context, number uint64) ([]*Entry, error) {
+	block, err := ix.client.BlockByNumber(ctx, new(big.Int).SetUint64(number))
+	if err != nil {
+		return nil, err
+	}
+	results, err := ix.fetcher.BlockReceipts(ctx, block)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]*Entry, 0, len(results))
+	for i, tx := range block.Transactions() {
+		if results[i].Err != nil {
+			return nil, results[i].Err
+		}
+		info, err := txinspect.Inspect(tx, ix.chainID, results[i].Receipt)
+		if err != nil {
+			return nil, err
+		}
+
+		to := info.To
+		if info.IsCreation() {
+			to = info.ContractAddress
+		}
+		entries = append(entries, &Entry{
+			TxHash:   info.Hash,
+			Block:    number,
+			Index:    uint(i),
+			From:     info.From,
+			To:       to,
+			Creation: info.IsCreation(),
+			Value:    info.Value,
+			Status:   info.Receipt.Status,
+		})
+	}
+	return entries, nil
+}
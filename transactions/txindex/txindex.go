@@ -0,0 +1,173 @@
+// Package txindex keeps an index of transactions by the addresses that sent
+// or received them.
+//
+// The node can tell us about a transaction given its hash, or about all the
+// transactions of a block, but not about all the transactions of an account.
+// The indexer walks a range of blocks once and records every transaction
+// under its sender and its recipient in LevelDB, together with the first and
+// last block indexed so that the next run only has to look at new blocks.
+// The indexed blocks always form one contiguous range.
+package txindex
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// Key layout:
+//
+//	"a" + address + block + txindex -> JSON encoded Entry
+//	"FirstBlock"                    -> number of the first indexed block
+//	"LastBlock"                     -> number of the last fully indexed block
+var (
+	addressPrefix = []byte("a")
+	firstBlockKey = []byte("FirstBlock")
+	lastBlockKey  = []byte("LastBlock")
+)
+
+// DefaultPageSize is the number of entries returned by Query when no limit
+// is given.
+const DefaultPageSize = 50
+
+// Entry is a transaction as recorded in the index.
+type Entry struct {
+	TxHash   common.Hash     `json:"txHash"`
+	Block    uint64          `json:"block"`
+	Index    uint            `json:"index"`
+	From     common.Address  `json:"from"`
+	To       *common.Address `json:"to"` // created contract address for contract creations
+	Creation bool            `json:"creation,omitempty"`
+	Value    *big.Int        `json:"value"`
+	Status   uint64          `json:"status"`
+}
+
+// Page is one page of query results. Next is empty on the last page,
+// otherwise it is the cursor to pass to Query for the following page.
+type Page struct {
+	Entries []*Entry
+	Next    string
+}
+
+// DB is the transaction index.
+type DB struct {
+	db *leveldb.DB
+}
+
+// Open opens or creates an index in the given directory.
+func Open(path string) (*DB, error) {
+	db, err := leveldb.OpenFile(path, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &DB{db: db}, nil
+}
+
+// Close releases the underlying database.
+func (db *DB) Close() error {
+	return db.db.Close()
+}
+
+// FirstBlock returns the number of the first indexed block. The boolean is
+// false if nothing has been indexed yet.
+func (db *DB) FirstBlock() (uint64, bool, error) {
+	return db.getUint64(firstBlockKey)
+}
+
+// LastBlock returns the number of the last fully indexed block. The boolean
+// is false if nothing has been indexed yet.
+func (db *DB) LastBlock() (uint64, bool, error) {
+	return db.getUint64(lastBlockKey)
+}
+
+func (db *DB) getUint64(key []byte) (uint64, bool, error) {
+	enc, err := db.db.Get(key, nil)
+	if err == leveldb.ErrNotFound {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	return binary.BigEndian.Uint64(enc), true, nil
+}
+
+// WriteBlock atomically stores the entries of one block and marks it as the
+// last indexed block, so that an interrupted run never leaves a block half
+// indexed. The first block written to an empty index is recorded as its
+// first block. Keeping the range contiguous is up to the caller.
+func (db *DB) WriteBlock(number uint64, entries []*Entry) error {
+	batch := new(leveldb.Batch)
+	if _, ok, err := db.LastBlock(); err != nil {
+		return err
+	} else if !ok {
+		batch.Put(firstBlockKey, encodeUint64(number))
+	}
+	for _, entry := range entries {
+		enc, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		batch.Put(entryKey(entry.From, entry.Block, entry.Index), enc)
+		if entry.To != nil && *entry.To != entry.From {
+			batch.Put(entryKey(*entry.To, entry.Block, entry.Index), enc)
+		}
+	}
+	batch.Put(lastBlockKey, encodeUint64(number))
+	return db.db.Write(batch, nil)
+}
+
+// Query returns the transactions sent or received by address in block order,
+// at most limit of them, starting at cursor. Pass an empty cursor for the
+// first page and Page.Next for the following ones.
+func (db *DB) Query(address common.Address, cursor string, limit int) (*Page, error) {
+	if limit <= 0 {
+		limit = DefaultPageSize
+	}
+	prefix := append(append([]byte{}, addressPrefix...), address.Bytes()...)
+	rng := util.BytesPrefix(prefix)
+	if cursor != "" {
+		start, err := hex.DecodeString(cursor)
+		if err != nil || len(start) != 12 {
+			return nil, errors.New("invalid cursor")
+		}
+		rng.Start = append(append([]byte{}, prefix...), start...)
+	}
+
+	it := db.db.NewIterator(rng, nil)
+	defer it.Release()
+
+	page := new(Page)
+	for it.Next() {
+		if len(page.Entries) == limit {
+			page.Next = hex.EncodeToString(it.Key()[len(prefix):])
+			break
+		}
+		entry := new(Entry)
+		if err := json.Unmarshal(it.Value(), entry); err != nil {
+			return nil, fmt.Errorf("corrupt entry %x: %v", it.Key(), err)
+		}
+		page.Entries = append(page.Entries, entry)
+	}
+	return page, it.Error()
+}
+
+func entryKey(address common.Address, block uint64, index uint) []byte {
+	key := append(append([]byte{}, addressPrefix...), address.Bytes()...)
+	key = append(key, encodeUint64(block)...)
+	idx := make([]byte, 4)
+	binary.BigEndian.PutUint32(idx, uint32(index))
+	return append(key, idx...)
+}
+
+func encodeUint64(n uint64) []byte {
+	enc := make([]byte, 8)
+	binary.BigEndian.PutUint64(enc, n)
+	return enc
+}
@@ -0,0 +1,143 @@
+// Package txinspect extracts the fields of a transaction that are not stored
+// in it directly, such as its sender and the address of the contract it
+// created, for every transaction type.
+//
+// The sender of a transaction is recovered from its signature, and what was
+// signed depends on the transaction type: unprotected legacy transactions
+// sign without a chain ID, EIP-155 legacy transactions mix the chain ID into
+// V, and typed transactions (EIP-2930 access lists, EIP-1559 dynamic fees)
+// sign a type-prefixed payload. Recovering with the wrong signer yields a
+// perfectly valid looking but wrong address, so the signer must be chosen per
+// transaction.
+package txinspect
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// Info describes a transaction and, if its receipt is known, its outcome.
+type Info struct {
+	Hash     common.Hash
+	Type     uint8
+	ChainID  *big.Int // nil for unprotected legacy transactions
+	Nonce    uint64
+	From     common.Address
+	To       *common.Address // nil for contract creations
+	Value    *big.Int
+	Gas      uint64
+	Data     []byte
+	GasPrice *big.Int // gas price of legacy and access-list transactions, fee cap otherwise
+
+	// GasFeeCap and GasTipCap are the maximum fee per gas and the maximum
+	// priority fee per gas. For transactions without them both equal the
+	// gas price.
+	GasFeeCap *big.Int
+	GasTipCap *big.Int
+
+	AccessList types.AccessList
+
+	// The following are only set when a receipt was supplied.
+	Receipt         *types.Receipt
+	ContractAddress *common.Address // address of the created contract
+}
+
+// IsCreation reports whether the transaction deploys a contract.
+func (info *Info) IsCreation() bool {
+	return info.To == nil
+}
+
+// TypeName returns a human-readable name for the transaction type.
+func (info *Info) TypeName() string {
+	return TypeName(info.Type)
+}
+
+// TypeName returns a human-readable name for a transaction type.
+func TypeName(txType uint8) string {
+	switch txType {
+	case types.LegacyTxType:
+		return "legacy"
+	case types.AccessListTxType:
+		return "access-list (EIP-2930)"
+	case types.DynamicFeeTxType:
+		return "dynamic-fee (EIP-1559)"
+	default:
+		return fmt.Sprintf("unknown (0x%02x)", txType)
+	}
+}
+
+// Signer returns the signer to recover the sender of tx with, on the chain
+// with the given ID. It fails if tx is replay protected for another chain.
+// The chain ID may be nil to accept tx for whatever chain it names; the
+// signer is always built from the chain ID in tx itself.
+func Signer(tx *types.Transaction, chainID *big.Int) (types.Signer, error) {
+	if tx.Protected() && chainID != nil && tx.ChainId().Cmp(chainID) != 0 {
+		return nil, fmt.Errorf("transaction %v is for chain %v, not %v", tx.Hash().Hex(), tx.ChainId(), chainID)
+	}
+	switch tx.Type() {
+	case types.LegacyTxType:
+		if !tx.Protected() {
+			return types.HomesteadSigner{}, nil
+		}
+		return types.NewEIP155Signer(tx.ChainId()), nil
+	case types.AccessListTxType:
+		return types.NewEIP2930Signer(tx.ChainId()), nil
+	case types.DynamicFeeTxType:
+		return types.NewLondonSigner(tx.ChainId()), nil
+	default:
+		// Newer transaction types are all understood by the latest signer.
+		return types.LatestSignerForChainID(tx.ChainId()), nil
+	}
+}
+
+// Sender recovers the address that signed tx on the given chain.
+func Sender(tx *types.Transaction, chainID *big.Int) (common.Address, error) {
+	signer, err := Signer(tx, chainID)
+	if err != nil {
+		return common.Address{}, err
+	}
+	return types.Sender(signer, tx)
+}
+
+// Inspect returns the details of tx on the chain with the given ID. The
+// receipt is optional; when given, the address of a created contract is
+// taken from it.
+func Inspect(tx *types.Transaction, chainID *big.Int, receipt *types.Receipt) (*Info, error) {
+	from, err := Sender(tx, chainID)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &Info{
+		Hash:       tx.Hash(),
+		Type:       tx.Type(),
+		Nonce:      tx.Nonce(),
+		From:       from,
+		To:         tx.To(),
+		Value:      tx.Value(),
+		Gas:        tx.Gas(),
+		Data:       tx.Data(),
+		GasPrice:   tx.GasPrice(),
+		GasFeeCap:  tx.GasFeeCap(),
+		GasTipCap:  tx.GasTipCap(),
+		AccessList: tx.AccessList(),
+		Receipt:    receipt,
+	}
+	if tx.Protected() {
+		info.ChainID = tx.ChainId()
+	}
+
+	if receipt != nil {
+		if receipt.TxHash != info.Hash {
+			return nil, fmt.Errorf("receipt %v does not belong to transaction %v", receipt.TxHash.Hex(), info.Hash.Hex())
+		}
+		if info.IsCreation() {
+			address := receipt.ContractAddress
+			info.ContractAddress = &address
+		}
+	}
+	return info, nil
+}
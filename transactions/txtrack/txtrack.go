@@ -0,0 +1,294 @@
+// Package txtrack follows a sent transaction until it is mined or lost, and
+// can replace a stuck one.
+//
+// Once broadcast, a transaction is in the node's pool (pending), in a block
+// (mined, with a receipt reporting success or failure), or neither. In the
+// last case either another transaction with the same nonce from the same
+// sender got mined (replaced), or the transaction fell out of the pool
+// before being mined (dropped) and can be sent again.
+//
+// A transaction that stays pending because its fees are too low can be
+// replaced by sending another one with the same nonce and fees at least 10%
+// higher, which is what nodes require to accept a replacement: either the
+// same transaction again (speed up) or a zero value transfer to the sender
+// itself (cancel).
+package txtrack
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	ethereum "github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// DefaultPollInterval is how often Wait checks on the transaction.
+const DefaultPollInterval = 4 * time.Second
+
+// MinBumpPercent is the fee increase nodes require to replace a pending
+// transaction.
+const MinBumpPercent = 10
+
+// State is where a transaction stands.
+type State int
+
+// The possible states.
+const (
+	Pending State = iota
+	Mined
+	Failed   // mined, but reverted
+	Replaced // another transaction with the same nonce was mined
+	Dropped  // neither pending nor mined, the nonce is still free
+)
+
+func (s State) String() string {
+	switch s {
+	case Pending:
+		return "pending"
+	case Mined:
+		return "mined"
+	case Failed:
+		return "failed"
+	case Replaced:
+		return "replaced"
+	case Dropped:
+		return "dropped"
+	default:
+		return fmt.Sprintf("state(%d)", int(s))
+	}
+}
+
+// Final reports whether the state can't change anymore, reorgs aside.
+func (s State) Final() bool {
+	return s == Mined || s == Failed || s == Replaced
+}
+
+// Status is an observation of a transaction.
+type Status struct {
+	State   State
+	Tx      *types.Transaction
+	Receipt *types.Receipt // set when mined or failed
+}
+
+func (s *Status) String() string {
+	switch s.State {
+	case Mined, Failed:
+		return fmt.Sprintf("%s %s in block %v (gas used %d)", s.Tx.Hash().Hex(), s.State, s.Receipt.BlockNumber, s.Receipt.GasUsed)
+	case Replaced:
+		return fmt.Sprintf("%s replaced, nonce %d was used by another transaction", s.Tx.Hash().Hex(), s.Tx.Nonce())
+	default:
+		return fmt.Sprintf("%s %s", s.Tx.Hash().Hex(), s.State)
+	}
+}
+
+// Backend is the part of ethclient.Client the tracker needs.
+type Backend interface {
+	TransactionByHash(ctx context.Context, hash common.Hash) (*types.Transaction, bool, error)
+	TransactionReceipt(ctx context.Context, hash common.Hash) (*types.Receipt, error)
+	NonceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (uint64, error)
+	HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error)
+	SendTransaction(ctx context.Context, tx *types.Transaction) error
+}
+
+// Tracker watches transactions sent from one account.
+type Tracker struct {
+	backend      Backend
+	from         common.Address
+	pollInterval time.Duration
+}
+
+// NewTracker returns a tracker for transactions sent from from. A zero poll
+// interval uses DefaultPollInterval.
+func NewTracker(backend Backend, from common.Address, pollInterval time.Duration) *Tracker {
+	if pollInterval <= 0 {
+		pollInterval = DefaultPollInterval
+	}
+	return &Tracker{backend: backend, from: from, pollInterval: pollInterval}
+}
+
+// Check looks up the current state of tx.
+func (t *Tracker) Check(ctx context.Context, tx *types.Transaction) (*Status, error) {
+	receipt, err := t.backend.TransactionReceipt(ctx, tx.Hash())
+	switch {
+	case err == nil:
+		state := Mined
+		if receipt.Status == types.ReceiptStatusFailed {
+			state = Failed
+		}
+		return &Status{State: state, Tx: tx, Receipt: receipt}, nil
+	case !errors.Is(err, ethereum.NotFound):
+		return nil, err
+	}
+
+	_, _, err = t.backend.TransactionByHash(ctx, tx.Hash())
+	switch {
+	case err == nil:
+		// Known to the node, either in the pool or mined but the receipt
+		// isn't indexed yet.
+		return &Status{State: Pending, Tx: tx}, nil
+	case !errors.Is(err, ethereum.NotFound):
+		return nil, err
+	}
+
+	nonce, err := t.backend.NonceAt(ctx, t.from, nil)
+	if err != nil {
+		return nil, err
+	}
+	if nonce > tx.Nonce() {
+		return &Status{State: Replaced, Tx: tx}, nil
+	}
+	return &Status{State: Dropped, Tx: tx}, nil
+}
+
+// Wait polls until one of txs, which must all have the same nonce, is mined
+// or the timeout expires. Pass the original transaction along with any
+// replacement sent for it. onChange, if not nil, is called every time the
+// state of one of them changes. The returned status is the one of the
+// transaction that was mined, or of the most recently sent one if none was
+// mined in time; in that case it is still pending, or dropped.
+func (t *Tracker) Wait(ctx context.Context, timeout time.Duration, onChange func(*Status), txs ...*types.Transaction) (*Status, error) {
+	if len(txs) == 0 {
+		return nil, errors.New("txtrack: no transaction to wait for")
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	last := make(map[common.Hash]State)
+	for {
+		var latest *Status
+		for _, tx := range txs {
+			status, err := t.Check(ctx, tx)
+			if err != nil {
+				if ctx.Err() != nil && latest != nil {
+					return latest, nil
+				}
+				return nil, err
+			}
+			if prev, seen := last[tx.Hash()]; (!seen || prev != status.State) && onChange != nil {
+				onChange(status)
+			}
+			last[tx.Hash()] = status.State
+
+			if status.State == Mined || status.State == Failed {
+				return status, nil
+			}
+			latest = status
+		}
+		if latest.State == Replaced {
+			// Replaced by a transaction we don't know about.
+			return latest, nil
+		}
+
+		select {
+		case <-time.After(t.pollInterval):
+		case <-ctx.Done():
+			return latest, nil
+		}
+	}
+}
+
+// SpeedUp sends tx again with its fees raised by bumpPercent, at least
+// MinBumpPercent, and returns the replacement.
+func (t *Tracker) SpeedUp(ctx context.Context, tx *types.Transaction, key *ecdsa.PrivateKey, bumpPercent int) (*types.Transaction, error) {
+	if tx.To() == nil {
+		return nil, errors.New("txtrack: can't speed up a contract creation, cancel it instead")
+	}
+	return t.replace(ctx, tx, key, bumpPercent, *tx.To(), tx.Value(), tx.Gas(), tx.Data(), tx.AccessList())
+}
+
+// Cancel replaces tx with a zero value transfer from the sender to itself
+// with fees raised by bumpPercent, at least MinBumpPercent. Once it is mined
+// tx can't be anymore. The replacement has no access list, which would cost
+// more than the 21000 gas of a plain transfer.
+func (t *Tracker) Cancel(ctx context.Context, tx *types.Transaction, key *ecdsa.PrivateKey, bumpPercent int) (*types.Transaction, error) {
+	return t.replace(ctx, tx, key, bumpPercent, t.from, new(big.Int), 21000, nil, nil)
+}
+
+func (t *Tracker) replace(ctx context.Context, tx *types.Transaction, key *ecdsa.PrivateKey, bumpPercent int,
+	to common.Address, value *big.Int, gas uint64, data []byte, accessList types.AccessList) (*types.Transaction, error) {
+
+	if crypto.PubkeyToAddress(key.PublicKey) != t.from {
+		return nil, fmt.Errorf("txtrack: key doesn't belong to sender %s", t.from.Hex())
+	}
+	if bumpPercent < MinBumpPercent {
+		bumpPercent = MinBumpPercent
+	}
+
+	// Make sure the replacement can still get into the next block when the
+	// base fee rose since the original was sent.
+	head, err := t.backend.HeaderByNumber(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var replacement types.TxData
+	switch tx.Type() {
+	case types.LegacyTxType:
+		replacement = &types.LegacyTx{
+			Nonce:    tx.Nonce(),
+			GasPrice: bump(tx.GasPrice(), bumpPercent),
+			Gas:      gas,
+			To:       &to,
+			Value:    value,
+			Data:     data,
+		}
+	case types.AccessListTxType:
+		replacement = &types.AccessListTx{
+			ChainID:    tx.ChainId(),
+			Nonce:      tx.Nonce(),
+			GasPrice:   bump(tx.GasPrice(), bumpPercent),
+			Gas:        gas,
+			To:         &to,
+			Value:      value,
+			Data:       data,
+			AccessList: accessList,
+		}
+	case types.DynamicFeeTxType:
+		tip := bump(tx.GasTipCap(), bumpPercent)
+		feeCap := bump(tx.GasFeeCap(), bumpPercent)
+		if head.BaseFee != nil {
+			if floor := new(big.Int).Add(head.BaseFee, tip); feeCap.Cmp(floor) < 0 {
+				feeCap = floor
+			}
+		}
+		replacement = &types.DynamicFeeTx{
+			ChainID:    tx.ChainId(),
+			Nonce:      tx.Nonce(),
+			GasTipCap:  tip,
+			GasFeeCap:  feeCap,
+			Gas:        gas,
+			To:         &to,
+			Value:      value,
+			Data:       data,
+			AccessList: accessList,
+		}
+	default:
+		return nil, fmt.Errorf("txtrack: can't replace transaction of type %d", tx.Type())
+	}
+
+	var signer types.Signer = types.HomesteadSigner{}
+	if tx.Protected() {
+		signer = types.LatestSignerForChainID(tx.ChainId())
+	}
+	signed, err := types.SignNewTx(key, signer, replacement)
+	if err != nil {
+		return nil, err
+	}
+	if err := t.backend.SendTransaction(ctx, signed); err != nil {
+		return nil, err
+	}
+	return signed, nil
+}
+
+// bump returns v raised by percent, rounded up.
+func bump(v *big.Int, percent int) *big.Int {
+	n := new(big.Int).Mul(v, big.NewInt(int64(100+percent)))
+	n.Add(n, big.NewInt(99))
+	return n.Div(n, big.NewInt(100))
+}
@@ -0,0 +1,66 @@
+package main
+
+/*
+
+  Verifying a Block Locally
+
+  Everything we've read so far from BlockByNumber and TransactionReceipt was
+  taken on trust from the RPC provider. We don't have to: the block hash is
+  the Keccak-256 hash of the RLP encoded header, and the header contains the
+  roots of the transaction trie and of the receipt trie. By rebuilding both
+  tries from the transactions and receipts we were given, we can tell whether
+  the provider handed us data that really belongs to that block.
+
+  $ go run verify_block.go -block 5671744
+
+*/
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"math/big"
+
+	"ethereum-go-book/transactions/blockverify"
+
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+func main() {
+	url := flag.String("rpc", "https://mainnet.infura.io", "JSON-RPC endpoint")
+	number := flag.Int64("block", 5671744, "block number to verify, -1 for the latest block")
+	flag.Parse()
+
+	// The verifier needs the raw RPC client because ethclient does not give
+	// us back the block hash the node reported, only the one it computed.
+
+	client, err := rpc.Dial(*url)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var blockNumber *big.Int
+	if *number >= 0 {
+		blockNumber = big.NewInt(*number)
+	}
+
+	verifier := blockverify.NewVerifier(client)
+	report, err := verifier.VerifyBlock(context.Background(), blockNumber)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Printf("\tBlock number: %v\n", report.Number)
+	fmt.Printf("\tReported hash: %v\n", report.ReportedHash.Hex())
+	fmt.Printf("\tComputed hash: %v\n", report.ComputedHash.Hex())
+	fmt.Printf("\tTx root:       %v\n", report.TxRoot.Hex())
+	fmt.Printf("\tComputed:      %v\n", report.ComputedTxRoot.Hex())
+	fmt.Printf("\tReceipt root:  %v\n", report.ReceiptRoot.Hex())
+	fmt.Printf("\tComputed:      %v\n", report.ComputedReceiptRoot.Hex())
+
+	if err := report.Err(); err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Println("\tBlock verified")
+}
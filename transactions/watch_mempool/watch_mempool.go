@@ -0,0 +1,165 @@
+package main
+
+/*
+
+  Watching the Mempool
+
+  The same subscription mechanism we used for new blocks works for
+  transactions that haven't been mined yet. The newPendingTransactions
+  subscription only gives us transaction hashes, so each one is looked up and
+  then filtered. This is how you'd notice someone front-running calls to your
+  own contract: watch for transactions to it carrying the selector you care
+  about.
+
+  $ go run watch_mempool.go -rpc wss://mainnet.infura.io/ws \
+      -to 0x28b149020d2152179873ec60bed6bf7cd705775d -selector "transfer(address,uint256),0x095ea7b3"
+
+  By default every match is printed as one line of JSON. Like every sink
+  record, it is wrapped in an envelope with the record kind, "pending", and
+  the time it was produced, and the match itself is under "data":
+
+    {"kind":"pending","time":"2018-09-16T02:04:09Z","data":{"hash":"0x...",...}}
+
+  With -sink the matches can also go to a rotating file or a webhook, or to
+  all of them.
+
+*/
+import (
+	"context"
+	"flag"
+	"log"
+	"math/big"
+	"strings"
+
+	"ethereum-go-book/transactions/calldata"
+	"ethereum-go-book/transactions/mempool"
+	"ethereum-go-book/transactions/sinks"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+func main() {
+	url := flag.String("rpc", "wss://mainnet.infura.io/ws", "websocket JSON-RPC endpoint")
+	to := flag.String("to", "", "comma separated recipients to match")
+	from := flag.String("from", "", "comma separated senders to match")
+	selectors := flag.String("selector", "", "comma separated 4-byte selectors (0x...) or function signatures to match")
+	minValue := flag.String("min-value", "", "minimum value in wei")
+	minGasPrice := flag.String("min-gas-price", "", "minimum gas price, or max fee per gas, in wei")
+	sinkSpecs := flag.String("sink", "stdout", "comma separated sinks: stdout, file:PATH or a webhook URL")
+	secret := flag.String("webhook-secret", "", "key to sign webhook requests with (HMAC-SHA256)")
+	flag.Parse()
+
+	filter := &mempool.Filter{
+		To:          parseAddresses(*to),
+		From:        parseAddresses(*from),
+		Selectors:   parseSelectors(*selectors),
+		MinValue:    parseWei(*minValue),
+		MinGasPrice: parseWei(*minGasPrice),
+	}
+
+	sink, err := sinks.Open(*sinkSpecs, *secret)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer sink.Close()
+
+	client, err := rpc.Dial(*url)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	watcher, err := mempool.NewWatcher(context.Background(), client, filter, calldata.Default())
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	matches := make(chan *mempool.Match)
+	var watchErr error
+	go func() {
+		watchErr = watcher.Watch(context.Background(), matches)
+		close(matches)
+	}()
+
+	for match := range matches {
+		if err := sink.Send(context.Background(), sinks.NewRecord("pending", match)); err != nil {
+			log.Printf("sink: %v", err)
+		}
+	}
+	if watchErr != nil {
+		log.Fatal(watchErr)
+	}
+}
+
+func parseAddresses(list string) []common.Address {
+	var addrs []common.Address
+	for _, s := range split(list) {
+		if !common.IsHexAddress(s) {
+			log.Fatalf("invalid address %q", s)
+		}
+		addrs = append(addrs, common.HexToAddress(s))
+	}
+	return addrs
+}
+
+func parseSelectors(list string) []calldata.Selector {
+	var sels []calldata.Selector
+	for _, s := range split(list) {
+		if strings.Contains(s, "(") {
+			sels = append(sels, calldata.SelectorOf(s))
+			continue
+		}
+		b, err := hexutil.Decode(s)
+		if err != nil || len(b) != 4 {
+			log.Fatalf("invalid selector %q", s)
+		}
+		var sel calldata.Selector
+		copy(sel[:], b)
+		sels = append(sels, sel)
+	}
+	return sels
+}
+
+func parseWei(s string) *big.Int {
+	if s == "" {
+		return nil
+	}
+	wei, ok := new(big.Int).SetString(s, 10)
+	if !ok {
+		log.Fatalf("invalid amount %q", s)
+	}
+	return wei
+}
+
+// split splits a comma separated list, leaving the commas between the
+// parentheses of a function signature alone.
+func split(list string) []string {
+	var (
+		items []string
+		depth int
+		start int
+	)
+	add := func(s string) {
+		if s = strings.TrimSpace(s); s != "" {
+			items = append(items, s)
+		}
+	}
+	for i, c := range list {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			if depth > 0 {
+				depth--
+			}
+		case ',':
+			if depth == 0 {
+				add(list[start:i])
+				start = i + 1
+			}
+		}
+	}
+	add(list[start:])
+	return items
+}